@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAdminUploadsLimit = 50
+	maxAdminUploadsLimit     = 1000
+)
+
+// AdminUploadsResponse is a page of recorded uploads returned by
+// /admin/uploads.
+type AdminUploadsResponse struct {
+	Uploads []uploadRecord `json:"uploads"`
+	Total   int            `json:"total"`
+	Limit   int            `json:"limit"`
+	Offset  int            `json:"offset"`
+}
+
+// handleAdminUploads pages through the recorded upload history, optionally
+// filtered by filename substring and creation time.
+func handleAdminUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if store == nil {
+		sendErrorResponse(w, "Upload persistence is not enabled", http.StatusNotFound)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultAdminUploadsLimit)
+	if err != nil || limit < 1 || limit > maxAdminUploadsLimit {
+		sendErrorResponse(w, fmt.Sprintf("Invalid limit: must be between 1 and %d", maxAdminUploadsLimit), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		sendErrorResponse(w, "Invalid offset: must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	filter := uploadFilter{
+		Filename: strings.TrimSpace(r.URL.Query().Get("filename")),
+		Limit:    limit,
+		Offset:   offset,
+	}
+
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "desc":
+	case "asc":
+		filter.Oldest = true
+	default:
+		sendErrorResponse(w, "Invalid order: must be asc or desc", http.StatusBadRequest)
+		return
+	}
+
+	if filter.From, err = queryTime(r, "from", false); err != nil {
+		sendErrorResponse(w, "Invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = queryTime(r, "to", true); err != nil {
+		sendErrorResponse(w, "Invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, total, err := store.listUploads(r.Context(), filter)
+	if err != nil {
+		loggerFrom(r.Context()).Error("Listing recorded uploads failed", "error", err)
+		sendErrorResponse(w, "Error listing uploads", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AdminUploadsResponse{
+		Uploads: records,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+	})
+}
+
+// queryTime parses an RFC 3339 timestamp or a YYYY-MM-DD date from the query
+// string, returning the zero time when key is absent. A bare date covers the
+// whole day, so endOfDay selects its last instant for upper bounds.
+func queryTime(r *http.Request, key string, endOfDay bool) (time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
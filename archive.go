@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultMaxArchiveExpandedSize = 100 << 20
+
+var (
+	// expandArchives makes /upload pin the files inside .zip, .tar and
+	// .tar.gz uploads instead of the archive itself.
+	expandArchives bool
+	// maxArchiveExpandedSize bounds the total size of the files extracted
+	// from one archive. Each file is also held to maxPerFileSize. Archives
+	// are extracted into memory, so a request can hold up to
+	// uploadConcurrency times this much while its archives are pinned.
+	maxArchiveExpandedSize int64 = defaultMaxArchiveExpandedSize
+)
+
+// archiveMember is a regular file extracted from an archive.
+type archiveMember struct {
+	path string
+	data []byte
+}
+
+// isArchive reports whether filename names an archive that is expanded
+// when expandArchives is set.
+func isArchive(filename string) bool {
+	name := strings.ToLower(filename)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinArchive extracts the archive fh and pins each file in it, returning
+// the archive's entry in the batch response. The archive as a whole is
+// rejected when it cannot be read, holds an entry whose path escapes the
+// archive root, or exceeds the size limits; files that fail validation or
+// upload are reported individually in ArchiveErrors. Dry runs compute the
+// members' CIDs without pinning them.
+func pinArchive(ctx context.Context, fh *multipart.FileHeader, sum string, opts uploadOptions, dry bool) (UploadResponse, error) {
+	members, err := extractArchive(fh)
+	if err != nil {
+		return UploadResponse{}, err
+	}
+	if len(members) == 0 {
+		return UploadResponse{}, withCode(CodeInvalidArchive, fmt.Errorf("archive %s contains no files", fh.Filename))
+	}
+
+	upload := UploadResponse{
+		PinataResponse: PinataResponse{Timestamp: time.Now().UTC().Format(time.RFC3339)},
+		Provider:       storageProvider,
+		SHA256:         sum,
+		DryRun:         dry,
+		ArchiveMembers: make(map[string]string, len(members)),
+	}
+	if opts.Options != nil {
+		upload.GroupID = opts.Options.GroupID
+	}
+	if !opts.ExpiresAt.IsZero() {
+		upload.ExpiresAt = &opts.ExpiresAt
+	}
+	start := time.Now()
+
+	for _, member := range members {
+		if ctx.Err() != nil {
+			return UploadResponse{}, ctx.Err()
+		}
+
+		err := validateUpload(member.path, int64(len(member.data)), func() (string, error) {
+			return http.DetectContentType(member.data[:min(len(member.data), sniffLen)]), nil
+		})
+		if err == nil {
+			err = validateImageDimensions(member.path, bytes.NewReader(member.data))
+		}
+		if err != nil {
+			upload.addArchiveError(member.path, err.Error())
+			continue
+		}
+
+		memberOpts := opts.withName(member.path)
+		if opts.Metadata != nil && opts.Metadata.Name != "" {
+			memberOpts = opts.withName(opts.Metadata.Name + "/" + member.path)
+		}
+
+		var result UploadResult
+		var cached bool
+		if dry {
+			result, err = simulateContent(member.data, memberOpts)
+		} else {
+			result, cached, err = uploadDeduped(ctx, bytesSHA256(member.data), memberOpts, func() (UploadResult, error) {
+				return uploadStripped(ctx, path.Base(member.path), bytes.NewReader(member.data), memberOpts)
+			})
+		}
+		if err != nil {
+			loggerFrom(ctx).Warn("Archive member upload failed", "archive", fh.Filename, "path", member.path, "error", err)
+			upload.addArchiveError(member.path, fmt.Sprintf("Error uploading %s: %v", member.path, err))
+			continue
+		}
+
+		upload.ArchiveMembers[member.path] = result.CID
+		upload.PinSize += result.Size
+		if !dry {
+			recordExpiringUpload(ctx, member.path, int64(len(member.data)), result.CID, opts.ExpiresAt)
+			if !cached {
+				chargeQuota(ctx, int64(len(member.data)))
+			}
+		}
+	}
+	upload.DurationMS = time.Since(start).Milliseconds()
+
+	if len(upload.ArchiveMembers) == 0 {
+		return UploadResponse{}, withCode(CodeUploadFailed, fmt.Errorf("no files in archive %s could be uploaded: %s", fh.Filename, strings.Join(archiveErrorList(upload.ArchiveErrors), "; ")))
+	}
+	loggerFrom(ctx).Info("Archive expanded", "archive", fh.Filename, "pinned", len(upload.ArchiveMembers), "failed", len(upload.ArchiveErrors), "dry_run", dry)
+	return upload, nil
+}
+
+func (u *UploadResponse) addArchiveError(path, message string) {
+	if u.ArchiveErrors == nil {
+		u.ArchiveErrors = make(map[string]string)
+	}
+	u.ArchiveErrors[path] = message
+}
+
+// archiveErrorList returns the messages of errs.
+func archiveErrorList(errs map[string]string) []string {
+	messages := make([]string, 0, len(errs))
+	for _, message := range errs {
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// simulateContent is simulateUpload for content held in memory.
+func simulateContent(data []byte, opts uploadOptions) (UploadResult, error) {
+	if stripEXIF {
+		if out, _, err := stripImageMetadata(data); err == nil {
+			data = out
+		}
+	}
+	builder := newUnixFSBuilder(opts.cidVersion())
+	builder.Write(data)
+	cid, err := builder.CID()
+	if err != nil {
+		return UploadResult{}, err
+	}
+	return UploadResult{Provider: storageProvider, CID: cid, Size: len(data)}, nil
+}
+
+// extractArchive reads every regular file in the archive fh into memory.
+// Directories, links and other special entries are skipped.
+func extractArchive(fh *multipart.FileHeader) ([]archiveMember, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return nil, withCode(CodeInternal, fmt.Errorf("failed to open archive %s: %v", fh.Filename, err))
+	}
+	defer file.Close()
+
+	x := &archiveExtractor{name: fh.Filename}
+	name := strings.ToLower(fh.Filename)
+	if strings.HasSuffix(name, ".zip") {
+		err = x.extractZip(file, fh.Size)
+	} else {
+		var r io.Reader = file
+		if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				return nil, withCode(CodeInvalidArchive, fmt.Errorf("archive %s is not valid gzip: %v", fh.Filename, err))
+			}
+			defer gz.Close()
+			r = gz
+		}
+		err = x.extractTar(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return x.members, nil
+}
+
+// archiveExtractor accumulates members while enforcing the limits that stop
+// a small archive from expanding into an unbounded amount of data.
+type archiveExtractor struct {
+	name    string
+	members []archiveMember
+	total   int64
+}
+
+func (x *archiveExtractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return withCode(CodeInvalidArchive, fmt.Errorf("archive %s is not a valid zip: %v", x.name, err))
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return withCode(CodeInvalidArchive, fmt.Errorf("archive %s: failed to read %s: %v", x.name, f.Name, err))
+		}
+		err = x.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExtractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return withCode(CodeInvalidArchive, fmt.Errorf("archive %s is not a valid tar: %v", x.name, err))
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := x.add(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// add reads one member, checking its path and the size limits as it goes
+// rather than trusting the sizes recorded in the archive.
+func (x *archiveExtractor) add(name string, r io.Reader) error {
+	memberPath, err := cleanRelativePath(name)
+	if err != nil {
+		return withCode(CodeInvalidArchive, fmt.Errorf("archive %s contains an unsafe path: %v", x.name, err))
+	}
+	if len(x.members) >= maxFilesPerUpload {
+		return withCode(CodeArchiveTooLarge, fmt.Errorf("archive %s contains more than %d files", x.name, maxFilesPerUpload))
+	}
+
+	limit := min(maxPerFileSize, maxArchiveExpandedSize-x.total)
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return withCode(CodeInvalidArchive, fmt.Errorf("archive %s: failed to read %s: %v", x.name, memberPath, err))
+	}
+	if int64(len(data)) > maxPerFileSize {
+		return withCode(CodeArchiveTooLarge, fmt.Errorf("archive %s: file %s exceeds per-file limit of %d bytes", x.name, memberPath, maxPerFileSize))
+	}
+	if int64(len(data)) > limit {
+		return withCode(CodeArchiveTooLarge, fmt.Errorf("archive %s expands to more than %d bytes", x.name, maxArchiveExpandedSize))
+	}
+
+	x.total += int64(len(data))
+	x.members = append(x.members, archiveMember{path: memberPath, data: data})
+	return nil
+}
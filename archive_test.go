@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"testing"
+	"time"
+)
+
+func TestPinArchiveChecksMembers(t *testing.T) {
+	useMockStorage(t)
+	prevWidth := maxImageWidth
+	maxImageWidth = 10
+	t.Cleanup(func() { maxImageWidth = prevWidth })
+
+	var wide bytes.Buffer
+	if err := png.Encode(&wide, image.NewGray(image.Rect(0, 0, 20, 1))); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string][]byte{"docs/readme.txt": []byte("hello"), "img/wide.png": wide.Bytes()} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	upload, err := pinArchive(context.Background(), archiveFile(t, "bundle.zip", archive.Bytes()), "sum", uploadOptions{ExpiresAt: expiresAt}, false)
+	if err != nil {
+		t.Fatalf("pinArchive: %v", err)
+	}
+
+	if _, ok := upload.ArchiveMembers["docs/readme.txt"]; !ok || len(upload.ArchiveMembers) != 1 {
+		t.Errorf("archive_members = %v, want only docs/readme.txt", upload.ArchiveMembers)
+	}
+	if _, ok := upload.ArchiveErrors["img/wide.png"]; !ok {
+		t.Errorf("archive_errors = %v, want img/wide.png rejected for its width", upload.ArchiveErrors)
+	}
+	if upload.ExpiresAt == nil || !upload.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expires_at = %v, want %v", upload.ExpiresAt, expiresAt)
+	}
+}
+
+// archiveFile returns the file header of an uploaded archive.
+func archiveFile(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	req := newUploadRequest(t, testFile{name, string(data)})
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	return req.MultipartForm.File["files"][0]
+}
+
+// tarArchive builds a tar archive holding files in order.
+func tarArchive(t *testing.T, files ...testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(f.content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPinArchiveRejectsArchives(t *testing.T) {
+	useMockStorage(t)
+	prevExpanded := maxArchiveExpandedSize
+	maxArchiveExpandedSize = 8
+	t.Cleanup(func() { maxArchiveExpandedSize = prevExpanded })
+
+	tests := []struct {
+		name string
+		data []byte
+		code ErrorCode
+	}{
+		{"unsafe path", tarArchive(t, testFile{"../evil.txt", "evil"}), CodeInvalidArchive},
+		{"expands past the limit", tarArchive(t, testFile{"a.txt", "12345"}, testFile{"b.txt", "67890"}), CodeArchiveTooLarge},
+		{"no files", tarArchive(t), CodeInvalidArchive},
+		{"not a tar", []byte("not an archive at all"), CodeInvalidArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := pinArchive(context.Background(), archiveFile(t, "bundle.tar", tt.data), "sum", uploadOptions{}, false)
+			if err == nil {
+				t.Fatal("pinArchive succeeded, want the archive rejected")
+			}
+			if code := errorCode(err, ""); code != tt.code {
+				t.Errorf("code = %s (%v), want %s", code, err, tt.code)
+			}
+		})
+	}
+}
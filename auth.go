@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const defaultJWTTTL = time.Hour
+
+var (
+	jwtSecret []byte
+	jwtTTL    = defaultJWTTTL
+
+	// apiKeys are the static keys accepted in the X-API-Key header.
+	apiKeys []string
+
+	// adminAPIKeys are accepted like apiKeys and also grant the admin role.
+	// adminUsers are the token subjects granted the admin role.
+	adminAPIKeys []string
+	adminUsers   []string
+)
+
+// adminKey is the context key marking requests authenticated as an admin.
+type adminKey struct{}
+
+// isAdmin reports whether the request carrying ctx authenticated with the
+// admin role.
+func isAdmin(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+// withAdmin marks r as authenticated with the admin role when admin is set.
+func withAdmin(r *http.Request, admin bool) *http.Request {
+	if !admin {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), adminKey{}, true))
+}
+
+// apiKeyCtxKey is the context key holding the API key a request
+// authenticated with.
+type apiKeyCtxKey struct{}
+
+// apiKeyFrom returns the API key the request carrying ctx authenticated
+// with, or "" when it used a token or no authentication.
+func apiKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyCtxKey{}).(string)
+	return key
+}
+
+// subjectCtxKey is the context key holding the subject of the token a
+// request authenticated with.
+type subjectCtxKey struct{}
+
+// principalFrom identifies who the request carrying ctx authenticated as,
+// for state that must not be shared between callers. It is empty when no
+// authentication is configured.
+func principalFrom(ctx context.Context) string {
+	if key := apiKeyFrom(ctx); key != "" {
+		return "key:" + key
+	}
+	if subject, ok := ctx.Value(subjectCtxKey{}).(string); ok {
+		return "user:" + subject
+	}
+	return ""
+}
+
+// LoginResponse is returned by /login on success.
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// jwtAuthEnabled reports whether /login issues tokens and protected routes
+// require them.
+func jwtAuthEnabled() bool {
+	return len(jwtSecret) > 0
+}
+
+// handleLogin exchanges valid Credentials for a signed JWT.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !jwtAuthEnabled() {
+		sendErrorResponse(w, "Login is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var creds Credentials
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		sendErrorResponse(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !validCredentials(creds) {
+		loggerFrom(r.Context()).Warn("Login failed", "username", creds.Username, "client_ip", clientIP(r))
+		sendErrorResponse(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	expiresAt := time.Now().Add(jwtTTL)
+	token, err := issueToken(creds.Username, expiresAt)
+	if err != nil {
+		loggerFrom(r.Context()).Error("Failed to sign token", "error", err)
+		sendErrorResponse(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LoginResponse{Token: token, ExpiresAt: expiresAt.UTC()})
+}
+
+// validCredentials compares creds against API_USERNAME and API_PASSWORD.
+// Both sides are hashed first so the comparison is constant-time regardless
+// of input length.
+func validCredentials(creds Credentials) bool {
+	username := os.Getenv("API_USERNAME")
+	password := os.Getenv("API_PASSWORD")
+	if username == "" || password == "" {
+		return false
+	}
+
+	userOK := hashEqual(creds.Username, username)
+	passOK := hashEqual(creds.Password, password)
+	return userOK && passOK
+}
+
+// hashEqual compares the SHA-256 digests of a and b in constant time.
+func hashEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// issueToken signs an HS256 JWT for subject that expires at expiresAt.
+func issueToken(subject string, expiresAt time.Time) (string, error) {
+	claims := jwt.StandardClaims{
+		Subject:   subject,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+}
+
+// parseToken validates a signed token and returns its claims.
+func parseToken(tokenString string) (*jwt.StandardClaims, error) {
+	claims := &jwt.StandardClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", errors.New("missing Authorization header")
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("malformed Authorization header")
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// apiKeyAuthEnabled reports whether static API keys are configured.
+func apiKeyAuthEnabled() bool {
+	return len(apiKeys) > 0 || len(adminAPIKeys) > 0
+}
+
+// validAPIKey reports whether key matches one of API_KEYS or ADMIN_API_KEYS,
+// and whether it is an admin key. Every configured key is compared in
+// constant time so timing does not reveal which matched.
+func validAPIKey(key string) (valid, admin bool) {
+	for _, candidate := range apiKeys {
+		if hashEqual(key, candidate) {
+			valid = true
+		}
+	}
+	for _, candidate := range adminAPIKeys {
+		if hashEqual(key, candidate) {
+			valid = true
+			admin = true
+		}
+	}
+	return valid, admin
+}
+
+// isAdminUser reports whether subject is listed in ADMIN_USERS.
+func isAdminUser(subject string) bool {
+	for _, user := range adminUsers {
+		if subject == user {
+			return true
+		}
+	}
+	return false
+}
+
+// keySuffix returns the last four characters of key for logging.
+func keySuffix(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "..." + key[len(key)-4:]
+}
+
+// authMiddleware requires either a valid X-API-Key or a bearer token issued
+// by /login, depending on which mechanisms are configured. It is a
+// pass-through when neither is.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !jwtAuthEnabled() && !apiKeyAuthEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if key := r.Header.Get("X-API-Key"); key != "" && apiKeyAuthEnabled() {
+			valid, admin := validAPIKey(key)
+			if !valid {
+				loggerFrom(r.Context()).Warn("Rejected request with invalid API key", "path", r.URL.Path, "key_suffix", keySuffix(key), "client_ip", clientIP(r))
+				sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyCtxKey{}, key))
+			next.ServeHTTP(w, withAdmin(r, admin))
+			return
+		}
+
+		if !jwtAuthEnabled() {
+			loggerFrom(r.Context()).Warn("Rejected request without API key", "path", r.URL.Path, "client_ip", clientIP(r))
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		token, err := bearerToken(r)
+		var claims *jwt.StandardClaims
+		if err == nil {
+			claims, err = parseToken(token)
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Warn("Rejected request with invalid token", "path", r.URL.Path, "error", err)
+			sendErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), subjectCtxKey{}, claims.Subject))
+		next.ServeHTTP(w, withAdmin(r, isAdminUser(claims.Subject)))
+	})
+}
+
+// requireAdmin rejects requests that authMiddleware did not mark as admin.
+// It must be wrapped by authMiddleware, and refuses everything when no
+// authentication is configured rather than leaving admin routes open.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r.Context()) {
+			loggerFrom(r.Context()).Warn("Rejected non-admin request", "path", r.URL.Path, "client_ip", clientIP(r))
+			sendErrorResponse(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
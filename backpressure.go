@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const defaultUploadQueueRetryAfter = 5 * time.Second
+
+var (
+	// uploadQueueDepth bounds the /upload requests admitted at once, each
+	// of which holds its files and a share of the upload workers. Zero
+	// admits every request.
+	uploadQueueDepth int
+	// uploadQueueWait is how long a request may wait for a free slot
+	// before it is rejected. Zero rejects it immediately.
+	uploadQueueWait time.Duration
+	// uploadQueueRetryAfter is the Retry-After sent with rejections.
+	uploadQueueRetryAfter = defaultUploadQueueRetryAfter
+)
+
+// uploadSlots holds one token per admitted /upload request. It is nil when
+// uploadQueueDepth is zero.
+var uploadSlots chan struct{}
+
+// queuedUploads is the number of admitted /upload requests, for /stats and
+// statsd.
+var queuedUploads atomic.Int64
+
+// newUploadSlots creates the admission queue when a depth is configured.
+func newUploadSlots(depth int) chan struct{} {
+	if depth <= 0 {
+		return nil
+	}
+	return make(chan struct{}, depth)
+}
+
+// limitUploadQueue sheds load once uploadQueueDepth requests are in
+// progress, answering 503 with Retry-After instead of letting work and
+// buffered bodies pile up.
+func limitUploadQueue(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if uploadSlots == nil {
+			next(w, r)
+			return
+		}
+
+		if !acquireUploadSlot(r) {
+			uploadQueueRejections.Inc()
+			statsd.count("upload_queue_rejections", 1)
+			loggerFrom(r.Context()).Warn("Upload queue is full, rejecting request", "depth", uploadQueueDepth)
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(uploadQueueRetryAfter.Seconds())), 1)))
+			sendCodedError(w, CodeServerBusy, "Server is busy, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		uploadQueueGauge.Set(float64(queuedUploads.Add(1)))
+		statsd.gauge("upload_queue_depth", queuedUploads.Load())
+		defer func() {
+			<-uploadSlots
+			uploadQueueGauge.Set(float64(queuedUploads.Add(-1)))
+			statsd.gauge("upload_queue_depth", queuedUploads.Load())
+		}()
+
+		next(w, r)
+	}
+}
+
+// acquireUploadSlot takes a slot, waiting up to uploadQueueWait for one to
+// free up. It gives up early if the client goes away.
+func acquireUploadSlot(r *http.Request) bool {
+	select {
+	case uploadSlots <- struct{}{}:
+		return true
+	default:
+	}
+	if uploadQueueWait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(uploadQueueWait)
+	defer timer.Stop()
+	select {
+	case uploadSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
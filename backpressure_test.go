@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUploadQueueShedsLoadWhenFull(t *testing.T) {
+	prevSlots, prevWait, prevRetryAfter := uploadSlots, uploadQueueWait, uploadQueueRetryAfter
+	uploadSlots, uploadQueueWait, uploadQueueRetryAfter = newUploadSlots(1), 10*time.Millisecond, 3*time.Second
+	t.Cleanup(func() { uploadSlots, uploadQueueWait, uploadQueueRetryAfter = prevSlots, prevWait, prevRetryAfter })
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := limitUploadQueue(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+
+	// The first request holds the only slot until it is released.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/upload", nil))
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status with a full queue = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want 3", got)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != CodeServerBusy {
+		t.Errorf("code = %s, want %s", body.Code, CodeServerBusy)
+	}
+
+	// Once the slot frees up, requests are admitted again.
+	close(release)
+	<-done
+	go func() { <-entered }()
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/upload", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after the slot was freed = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ndjsonContentType is the media type clients send in Accept to receive
+// per-file results as they complete.
+const ndjsonContentType = "application/x-ndjson"
+
+// UploadEvent is a single NDJSON line describing one file's outcome. Index
+// is the file's position in the request, since events arrive in completion
+// order.
+type UploadEvent struct {
+	Index    int             `json:"index"`
+	Status   string          `json:"status"`
+	Filename string          `json:"filename"`
+	Upload   *UploadResponse `json:"upload,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Code     ErrorCode       `json:"code,omitempty"`
+}
+
+// fileOutcome is the recorded result for one file in a batch. Exactly one of
+// upload and err is set once the file has been processed.
+type fileOutcome struct {
+	upload *UploadResponse
+	err    *FileError
+}
+
+// uploadBatch collects the per-file outcomes of a single /upload request.
+// Outcomes are stored by the file's position so the response lists files in
+// request order however the uploads complete. It is safe for concurrent use
+// by the upload workers.
+type uploadBatch struct {
+	mu        sync.Mutex
+	outcomes  []fileOutcome
+	totalSize int64
+
+	// stream is non-nil when results are written to the client as NDJSON.
+	stream *ndjsonStream
+}
+
+// newUploadBatch prepares a batch for n files.
+func newUploadBatch(n int) *uploadBatch {
+	return &uploadBatch{outcomes: make([]fileOutcome, n)}
+}
+
+// succeed records a successful upload of size bytes for the file at index.
+func (b *uploadBatch) succeed(index int, filename string, size int64, upload UploadResponse) {
+	countUpload("success")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.outcomes[index] = fileOutcome{upload: &upload}
+	b.totalSize += size
+	if b.stream != nil {
+		b.stream.write(UploadEvent{Index: index, Status: "success", Filename: filename, Upload: &upload})
+	}
+}
+
+// fail records an error for the file at index. message is reported to the
+// client as-is.
+func (b *uploadBatch) fail(index int, filename string, code ErrorCode, message string) {
+	countUpload("error")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.outcomes[index] = fileOutcome{err: &FileError{Filename: filename, Code: code, Message: message}}
+	if b.stream != nil {
+		b.stream.write(UploadEvent{Index: index, Status: "error", Filename: filename, Error: message, Code: code})
+	}
+}
+
+// result returns the aggregated response body, with successes and errors
+// each in request order.
+func (b *uploadBatch) result() BatchUploadResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := BatchUploadResponse{
+		SuccessfulUploads: make([]UploadResponse, 0, len(b.outcomes)),
+		Errors:            make([]FileError, 0),
+	}
+	for _, outcome := range b.outcomes {
+		switch {
+		case outcome.upload != nil:
+			result.SuccessfulUploads = append(result.SuccessfulUploads, *outcome.upload)
+		case outcome.err != nil:
+			result.Errors = append(result.Errors, *outcome.err)
+		}
+	}
+	return result
+}
+
+// addError appends the error for one file.
+func (r *BatchUploadResponse) addError(filename string, code ErrorCode, message string) {
+	r.Errors = append(r.Errors, FileError{Filename: filename, Code: code, Message: message})
+}
+
+// ndjsonStream writes one JSON object per line, flushing after each so the
+// client sees results as soon as they are available.
+type ndjsonStream struct {
+	enc     *json.Encoder
+	flusher http.Flusher
+}
+
+// wantsNDJSON reports whether the client asked for streamed results.
+func wantsNDJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// startNDJSON writes the response header and returns a stream for results.
+func startNDJSON(w http.ResponseWriter) *ndjsonStream {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	stream := &ndjsonStream{enc: json.NewEncoder(w), flusher: flusher}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return stream
+}
+
+func (s *ndjsonStream) write(event UploadEvent) {
+	s.enc.Encode(event)
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerWindow    = 30 * time.Second
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// errCircuitOpen is returned without contacting Pinata while the breaker is
+// open.
+var errCircuitOpen = errors.New("pinata is unavailable, circuit breaker is open")
+
+// breakerState is the state of a circuitBreaker. The values are exported as
+// the fileupload_pinata_circuit_state gauge.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker fails calls fast after threshold consecutive failures
+// within window. Once cooldown has passed a single probe call is let
+// through: success closes the circuit, failure opens it again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	firstFail time.Time
+	openedAt  time.Time
+	probing   bool
+
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+}
+
+// pinataBreaker guards requests to Pinata. A threshold of zero disables it.
+var pinataBreaker = &circuitBreaker{
+	threshold: defaultBreakerThreshold,
+	window:    defaultBreakerWindow,
+	cooldown:  defaultBreakerCooldown,
+}
+
+// allow reports whether a call may proceed, moving an open circuit to
+// half-open once the cooldown has elapsed.
+func (b *circuitBreaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return errCircuitOpen
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record reports the outcome of a call that allow let through. Only
+// failures that suggest Pinata itself is unhealthy should be passed as
+// failed, and only a successful response as a success; calls with any other
+// outcome are released instead.
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		b.probing = false
+		b.setState(breakerClosed)
+		return
+	}
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		b.open()
+		return
+	}
+
+	if b.failures == 0 || time.Since(b.firstFail) > b.window {
+		b.failures = 0
+		b.firstFail = time.Now()
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+// release ends a call that allow let through without recording an outcome,
+// such as one the client canceled. The state is unchanged, but a half-open
+// circuit lets the next call probe.
+func (b *circuitBreaker) release() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// open trips the circuit. The caller holds b.mu.
+func (b *circuitBreaker) open() {
+	if b.state != breakerOpen {
+		logger.Warn("Circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown)
+	}
+	b.failures = 0
+	b.openedAt = time.Now()
+	b.setState(breakerOpen)
+}
+
+// setState updates the state and its gauge. The caller holds b.mu.
+func (b *circuitBreaker) setState(state breakerState) {
+	b.state = state
+	pinataCircuitState.Set(float64(state))
+}
+
+// status returns the current state and, when open, how long until a probe
+// is allowed.
+func (b *circuitBreaker) status() (breakerState, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		return b.state, max(b.cooldown-time.Since(b.openedAt), 0)
+	}
+	return b.state, 0
+}
+
+// rejectIfCircuitOpen answers with 503 and returns true when Pinata uploads
+// would fail fast anyway, so the request body is not read for nothing. With
+// a fallback provider configured the upload can still succeed, so nothing
+// is rejected.
+func rejectIfCircuitOpen(w http.ResponseWriter) bool {
+	if _, ok := storage.(PinataProvider); !ok || fallbackStorage != nil {
+		return false
+	}
+
+	state, retryIn := pinataBreaker.status()
+	if state != breakerOpen || retryIn == 0 {
+		return false
+	}
+	sendCircuitOpen(w, retryIn)
+	return true
+}
+
+// sendCircuitOpen writes the 503 returned while the breaker is open.
+func sendCircuitOpen(w http.ResponseWriter, retryIn time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(retryIn.Seconds())), 1)))
+	sendCodedError(w, CodePinataUnavailable, "Pinata is temporarily unavailable, try again later", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/ipfs/go-cid"
+)
+
+// normalizeCIDv1 adds a CIDv1 base32 form of each returned CID, for
+// subdomain gateways that do not accept CIDv0.
+var normalizeCIDv1 bool
+
+// cidV1 returns the CIDv1 base32 form of s when NORMALIZE_CIDV1 is enabled,
+// or "" when it is disabled or s cannot be parsed. The conversion is purely
+// local.
+func cidV1(s string) string {
+	if !normalizeCIDv1 {
+		return ""
+	}
+
+	c, err := cid.Decode(s)
+	if err != nil {
+		logger.Warn("Could not convert CID to CIDv1", "cid", s, "error", err)
+		return ""
+	}
+	return cid.NewCidV1(c.Type(), c.Hash()).String()
+}
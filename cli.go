@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// CLIUploadResult is the outcome of pinning one path with the upload
+// subcommand.
+type CLIUploadResult struct {
+	Path    string `json:"path"`
+	CID     string `json:"cid,omitempty"`
+	PinSize int    `json:"pin_size,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runUploadCommand implements "fileupload upload [flags] file...". Each
+// path is pinned with the configured storage provider and the results are
+// printed to stdout as a JSON array in argument order. It returns the
+// process exit code: 1 when any file failed, 2 for usage errors.
+func runUploadCommand(args []string) int {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileupload upload [flags] file...")
+		flags.PrintDefaults()
+	}
+	cidVersion := flags.Int("cid-version", 0, "CID version to pin with, 0 or 1")
+	groupID := flags.String("group", "", "Pinata group to add the files to")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *cidVersion != 0 && *cidVersion != 1 {
+		fmt.Fprintln(flags.Output(), "-cid-version must be 0 or 1")
+		return 2
+	}
+
+	var opts uploadOptions
+	if *cidVersion == 1 {
+		opts.Options = &PinataOptions{CIDVersion: cidVersion}
+	}
+	if *groupID != "" {
+		opts = opts.withGroup(*groupID)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Files are pinned with the same concurrency limit as a server batch.
+	results := make([]CLIUploadResult, flags.NArg())
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, uploadConcurrency)
+	for i, path := range flags.Args() {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = uploadPath(ctx, path, opts)
+		}(i, path)
+	}
+	wg.Wait()
+
+	code := 0
+	for _, result := range results {
+		if result.Error != "" {
+			code = 1
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		logger.Error("Failed to write results", "error", err)
+		return 1
+	}
+	return code
+}
+
+// uploadPath pins the file at path under its base name.
+func uploadPath(ctx context.Context, path string, opts uploadOptions) CLIUploadResult {
+	file, err := os.Open(path)
+	if err != nil {
+		return CLIUploadResult{Path: path, Error: err.Error()}
+	}
+	defer file.Close()
+
+	result, err := uploadStripped(ctx, filepath.Base(path), file, opts)
+	if err != nil {
+		logger.Warn("Upload failed", "path", path, "error", err)
+		return CLIUploadResult{Path: path, Error: err.Error()}
+	}
+	logger.Info("Upload succeeded", "path", path, "cid", result.CID)
+	return CLIUploadResult{Path: path, CID: result.CID, PinSize: result.Size}
+}
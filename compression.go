@@ -0,0 +1,43 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errInvalidGzip is reported for request bodies that claim gzip encoding
+// but cannot be decompressed.
+var errInvalidGzip = errors.New("invalid gzip request body")
+
+// gzipRequestBody replaces a gzip-encoded r.Body with its decompressed
+// stream, capped at limit decompressed bytes so a small body cannot expand
+// without bound. It reports whether the body was gzip-encoded; other bodies
+// are left alone.
+func gzipRequestBody(w http.ResponseWriter, r *http.Request, limit int64) (bool, error) {
+	if !strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip") {
+		return false, nil
+	}
+
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return true, errInvalidGzip
+	}
+	r.Body = http.MaxBytesReader(w, gz, limit)
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return true, nil
+}
+
+// isGzipError reports whether err came from decompressing a corrupt or
+// truncated gzip stream.
+func isGzipError(err error) bool {
+	var corrupt flate.CorruptInputError
+	return errors.Is(err, gzip.ErrHeader) ||
+		errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &corrupt)
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings that only main needs are kept here alongside the loader; the
+// rest live next to the code that uses them.
+var (
+	listenAddr      = fmt.Sprintf(":%d", defaultPort)
+	tlsCertFile     string
+	tlsKeyFile      string
+	shutdownTimeout = defaultShutdownTimeout
+
+	// Server timeouts. ReadTimeout and WriteTimeout both span the request
+	// body, and WriteTimeout also covers the time spent uploading to Pinata
+	// before responding, so they must allow for the largest legitimate
+	// upload on a slow link. ReadHeaderTimeout is what stops slow-loris
+	// clients and can stay short.
+	readHeaderTimeout = defaultReadHeaderTimeout
+	readTimeout       = defaultReadTimeout
+	writeTimeout      = defaultWriteTimeout
+	idleTimeout       = defaultIdleTimeout
+
+	// requestUploadDeadline bounds a whole /upload request, from reading
+	// the body to the last file's upload, independently of the per-file
+	// Pinata timeout. Zero leaves requests unbounded.
+	requestUploadDeadline time.Duration
+
+	rateLimitRPS   = float64(defaultRateLimitRPS)
+	rateLimitBurst = defaultRateLimitBurst
+)
+
+// validateConfig checks that the settings required to serve requests are
+// present and well-formed, reporting every problem at once.
+func validateConfig() error {
+	var errs []error
+
+	if _, err := newStorageProvider(os.Getenv("STORAGE_PROVIDER")); err != nil {
+		errs = append(errs, fmt.Errorf("invalid STORAGE_PROVIDER: %w", err))
+	}
+	secondary := os.Getenv("SECONDARY_PROVIDER")
+	if secondary != "" {
+		if _, err := newStorageProvider(secondary); err != nil {
+			errs = append(errs, fmt.Errorf("invalid SECONDARY_PROVIDER: %w", err))
+		} else if secondary == cmp.Or(os.Getenv("STORAGE_PROVIDER"), defaultStorageProvider) {
+			errs = append(errs, errors.New("SECONDARY_PROVIDER must differ from STORAGE_PROVIDER"))
+		}
+	}
+	fallback := os.Getenv("FALLBACK_PROVIDER")
+	if fallback != "" {
+		if _, err := newStorageProvider(fallback); err != nil {
+			errs = append(errs, fmt.Errorf("invalid FALLBACK_PROVIDER: %w", err))
+		} else if fallback == cmp.Or(os.Getenv("STORAGE_PROVIDER"), defaultStorageProvider) {
+			errs = append(errs, errors.New("FALLBACK_PROVIDER must differ from STORAGE_PROVIDER"))
+		}
+	}
+	// The mock provider runs without any external service.
+	if os.Getenv("STORAGE_PROVIDER") != "mock" || secondary == "pinata" || fallback == "pinata" {
+		if os.Getenv("PINATA_API_URL") == "" {
+			errs = append(errs, errors.New("PINATA_API_URL is required"))
+		}
+		if !hasPinataCredentials() {
+			errs = append(errs, errors.New("Pinata credentials are required: set PINATA_JWT or both PINATA_API_KEY and PINATA_API_SECRET"))
+		}
+	}
+	if key := os.Getenv("ENCRYPTION_KEY"); key != "" {
+		if _, err := parseEncryptionKey(key); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ENCRYPTION_KEY: %w", err))
+		}
+	}
+	if rate := os.Getenv("MOCK_FAILURE_RATE"); rate != "" {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(rate), 64); err != nil || f < 0 || f > 1 {
+			errs = append(errs, errors.New("MOCK_FAILURE_RATE must be a number between 0 and 1"))
+		}
+	}
+	if proxy := os.Getenv("PINATA_PROXY_URL"); proxy != "" {
+		if _, err := parseProxyURL(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("invalid PINATA_PROXY_URL: %w", err))
+		}
+	}
+	if entries := envList("API_KEY_QUOTAS", nil); len(entries) > 0 {
+		quotas, err := parseAPIKeyQuotas(entries)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid API_KEY_QUOTAS: %w", err))
+		} else if err := validateAPIKeyQuotas(quotas, append(envList("API_KEYS", nil), envList("ADMIN_API_KEYS", nil)...)); err != nil {
+			errs = append(errs, fmt.Errorf("invalid API_KEY_QUOTAS: %w", err))
+		}
+		if os.Getenv("DB_PATH") == "" {
+			errs = append(errs, errors.New("API_KEY_QUOTAS requires persistence (DB_PATH)"))
+		}
+	}
+	if addr := os.Getenv("CLAMAV_ADDR"); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid CLAMAV_ADDR: %w", err))
+		}
+	}
+	if addr := os.Getenv("STATSD_ADDR"); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid STATSD_ADDR: %w", err))
+		}
+	}
+	if _, err := parsePort(os.Getenv("PORT")); err != nil {
+		errs = append(errs, fmt.Errorf("invalid PORT: %w", err))
+	}
+	if addr := os.Getenv("LISTEN_ADDR"); addr != "" {
+		if err := validateListenAddr(addr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid LISTEN_ADDR: %w", err))
+		}
+	}
+	if mode := os.Getenv("UNIX_SOCKET_MODE"); mode != "" {
+		if _, err := parseSocketMode(mode); err != nil {
+			errs = append(errs, fmt.Errorf("invalid UNIX_SOCKET_MODE: %w", err))
+		}
+	}
+	if _, err := parseTrustedProxies(envList("TRUSTED_PROXIES", nil)); err != nil {
+		errs = append(errs, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err))
+	}
+	if (os.Getenv("TLS_CERT_FILE") == "") != (os.Getenv("TLS_KEY_FILE") == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadConfig reads the environment into the package-level settings. It
+// expects validateConfig to have passed.
+func loadConfig() {
+	// The Pinata client is built first so the providers below are wired with
+	// it.
+	pinataMaxIdleConns = envInt("PINATA_MAX_IDLE_CONNS", defaultPinataMaxIdleConns)
+	pinataMaxIdleConnsPerHost = envInt("PINATA_MAX_IDLE_CONNS_PER_HOST", defaultPinataMaxIdleConnsPerHost)
+	pinataMaxConnsPerHost = envInt("PINATA_MAX_CONNS_PER_HOST", defaultPinataMaxConnsPerHost)
+	pinataIdleConnTimeout = envDuration("PINATA_IDLE_CONN_TIMEOUT", defaultPinataIdleConnTimeout)
+	if proxy := os.Getenv("PINATA_PROXY_URL"); proxy != "" {
+		pinataProxyURL, _ = parseProxyURL(proxy)
+	}
+	pinataClient = newPinataClient()
+	userAgent = cmp.Or(strings.TrimSpace(os.Getenv("HTTP_USER_AGENT")), defaultUserAgent())
+
+	storageProvider = os.Getenv("STORAGE_PROVIDER")
+	if storageProvider == "" {
+		storageProvider = defaultStorageProvider
+	}
+	storage, _ = newStorageProvider(storageProvider)
+	secondaryStorageProvider = os.Getenv("SECONDARY_PROVIDER")
+	if secondaryStorageProvider != "" {
+		secondaryStorage, _ = newStorageProvider(secondaryStorageProvider)
+	}
+	requireBothProviders = os.Getenv("REQUIRE_BOTH") == "true"
+	fallbackStorageProvider = os.Getenv("FALLBACK_PROVIDER")
+	if fallbackStorageProvider != "" {
+		fallbackStorage, _ = newStorageProvider(fallbackStorageProvider)
+	}
+
+	maxFileSize = envSize("MAX_FILE_SIZE", defaultMaxFileSize)
+	maxPerFileSize = envSize("MAX_PER_FILE_SIZE", maxFileSize)
+	maxRequestBytes = envSize("MAX_REQUEST_BYTES", defaultMaxRequestBytes)
+	uploadTempDir = os.Getenv("UPLOAD_TEMP_DIR")
+	pinataMaxRetries = envInt("PINATA_MAX_RETRIES", defaultPinataMaxRetries)
+	pinataTimeout = envDuration("PINATA_TIMEOUT", defaultPinataTimeout)
+	maxRetryAfter = envDuration("PINATA_MAX_RETRY_AFTER", defaultMaxRetryAfter)
+	uploadConcurrency = envInt("UPLOAD_CONCURRENCY", defaultUploadConcurrency)
+	if uploadConcurrency < 1 {
+		logger.Warn("UPLOAD_CONCURRENCY must be at least 1, using default", "default", defaultUploadConcurrency)
+		uploadConcurrency = defaultUploadConcurrency
+	}
+	maxFilesPerUpload = envInt("MAX_FILES_PER_REQUEST", defaultMaxFilesPerUpload)
+	if maxFilesPerUpload < 1 {
+		logger.Warn("MAX_FILES_PER_REQUEST must be at least 1, using default", "default", defaultMaxFilesPerUpload)
+		maxFilesPerUpload = defaultMaxFilesPerUpload
+	}
+	if gateway := os.Getenv("IPFS_GATEWAY"); gateway != "" {
+		ipfsGateway = gateway
+	}
+	normalizeCIDv1 = os.Getenv("NORMALIZE_CIDV1") == "true"
+	verifyCID = os.Getenv("VERIFY_CID") == "true"
+	stripEXIF = os.Getenv("STRIP_EXIF") == "true"
+	dryRun = os.Getenv("DRY_RUN") == "true"
+	expandArchives = os.Getenv("EXPAND_ARCHIVES") == "true"
+	maxArchiveExpandedSize = envSize("MAX_ARCHIVE_EXPANDED_SIZE", defaultMaxArchiveExpandedSize)
+	pinataGroupID = os.Getenv("PINATA_GROUP_ID")
+	if baseURL := os.Getenv("PINATA_BASE_URL"); baseURL != "" {
+		pinataBaseURL = strings.TrimRight(baseURL, "/")
+	}
+
+	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+	jwtTTL = envDuration("JWT_TTL", defaultJWTTTL)
+	apiKeys = envList("API_KEYS", nil)
+	adminAPIKeys = envList("ADMIN_API_KEYS", nil)
+	adminUsers = envList("ADMIN_USERS", nil)
+	apiKeyQuotas, _ = parseAPIKeyQuotas(envList("API_KEY_QUOTAS", nil))
+	if !jwtAuthEnabled() && !apiKeyAuthEnabled() {
+		logger.Warn("Neither JWT_SECRET nor API_KEYS is set, API routes do not require authentication")
+	}
+
+	if key, err := parseEncryptionKey(os.Getenv("ENCRYPTION_KEY")); err == nil {
+		encryptionAEAD, _ = newEncryptionAEAD(key)
+	}
+
+	webhookURL = os.Getenv("WEBHOOK_URL")
+	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+	slackWebhookURL = os.Getenv("SLACK_WEBHOOK_URL")
+	slackFailureThreshold = envInt("SLACK_FAILURE_THRESHOLD", defaultSlackFailureThreshold)
+	if slackFailureThreshold < 1 {
+		logger.Warn("SLACK_FAILURE_THRESHOLD must be at least 1, using default", "default", defaultSlackFailureThreshold)
+		slackFailureThreshold = defaultSlackFailureThreshold
+	}
+	slackAlertInterval = envDuration("SLACK_ALERT_INTERVAL", defaultSlackAlertInterval)
+	urlFetchTimeout = envDuration("URL_FETCH_TIMEOUT", defaultURLFetchTimeout)
+	idempotencyTTL = envDuration("IDEMPOTENCY_TTL", defaultIdempotencyTTL)
+	dedupCacheEnabled = os.Getenv("DEDUP_CACHE") == "true"
+	dedupCacheTTL = envDuration("DEDUP_CACHE_TTL", defaultDedupCacheTTL)
+	pinStatusCacheTTL = envDuration("PIN_STATUS_CACHE_TTL", defaultPinStatusCacheTTL)
+	repinCheckInterval = envDuration("REPIN_CHECK_INTERVAL", 0)
+	expiryCheckInterval = envDuration("EXPIRY_CHECK_INTERVAL", defaultExpiryCheckInterval)
+	statsdAddr = os.Getenv("STATSD_ADDR")
+	statsdPrefix = cmp.Or(os.Getenv("STATSD_PREFIX"), defaultStatsdPrefix)
+	statsdTags = envList("STATSD_TAGS", nil)
+	allowPrivateFetch = os.Getenv("ALLOW_PRIVATE_URLS") == "true"
+	corsAllowedOrigins = envList("CORS_ALLOWED_ORIGINS", corsAllowedOrigins)
+	// An explicitly empty CONTENT_SECURITY_POLICY turns the header off.
+	if csp, set := os.LookupEnv("CONTENT_SECURITY_POLICY"); set {
+		contentSecurityPolicy = strings.TrimSpace(csp)
+	}
+	uploadFieldNames = envList("UPLOAD_FIELD_NAMES", uploadFieldNames)
+	allowedMIMETypes = envList("ALLOWED_MIME_TYPES", nil)
+	blockedExtensions = parseBlockedExtensions(envList("BLOCKED_EXTENSIONS", nil))
+	clamavAddr = os.Getenv("CLAMAV_ADDR")
+	thumbnailSize = envInt("THUMBNAIL_SIZE", 0)
+	maxImageWidth = envInt("MAX_IMAGE_WIDTH", 0)
+	maxImageHeight = envInt("MAX_IMAGE_HEIGHT", 0)
+	clamavTimeout = envDuration("CLAMAV_TIMEOUT", defaultClamAVTimeout)
+
+	pinataBreaker.threshold = envInt("CIRCUIT_BREAKER_THRESHOLD", defaultBreakerThreshold)
+	pinataBreaker.window = envDuration("CIRCUIT_BREAKER_WINDOW", defaultBreakerWindow)
+	pinataBreaker.cooldown = envDuration("CIRCUIT_BREAKER_COOLDOWN", defaultBreakerCooldown)
+
+	rateLimitRPS = envFloat("RATE_LIMIT_RPS", defaultRateLimitRPS)
+	rateLimitBurst = envInt("RATE_LIMIT_BURST", defaultRateLimitBurst)
+	trustedProxies, _ = parseTrustedProxies(envList("TRUSTED_PROXIES", nil))
+
+	port, _ := parsePort(os.Getenv("PORT"))
+	listenAddr = net.JoinHostPort(os.Getenv("HOST"), strconv.Itoa(port))
+	// LISTEN_ADDR takes precedence over HOST and PORT, and is the only way
+	// to listen on a Unix socket.
+	listenAddr = cmp.Or(os.Getenv("LISTEN_ADDR"), listenAddr)
+	if mode := os.Getenv("UNIX_SOCKET_MODE"); mode != "" {
+		unixSocketMode, _ = parseSocketMode(mode)
+	}
+	tlsCertFile = os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile = os.Getenv("TLS_KEY_FILE")
+	shutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	readHeaderTimeout = envDuration("SERVER_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout)
+	readTimeout = envDuration("SERVER_READ_TIMEOUT", defaultReadTimeout)
+	writeTimeout = envDuration("SERVER_WRITE_TIMEOUT", defaultWriteTimeout)
+	idleTimeout = envDuration("SERVER_IDLE_TIMEOUT", defaultIdleTimeout)
+	requestUploadDeadline = envDuration("REQUEST_UPLOAD_DEADLINE", 0)
+	uploadQueueDepth = envInt("UPLOAD_QUEUE_DEPTH", 0)
+	uploadQueueWait = envDuration("UPLOAD_QUEUE_WAIT", 0)
+	uploadQueueRetryAfter = envDuration("UPLOAD_QUEUE_RETRY_AFTER", defaultUploadQueueRetryAfter)
+}
+
+// logConfig logs the effective configuration with secrets redacted.
+func logConfig() {
+	logger.Info("Configuration loaded",
+		"version", version,
+		"commit", commit,
+		"user_agent", userAgent,
+		"config_file", configFilePath,
+		"listen_addr", listenAddr,
+		"unix_socket_mode", fmt.Sprintf("%#o", unixSocketMode),
+		"tls", tlsCertFile != "",
+		"storage_provider", storageProvider,
+		"secondary_provider", secondaryStorageProvider,
+		"require_both", requireBothProviders,
+		"fallback_provider", fallbackStorageProvider,
+		"pinata_api_url", os.Getenv("PINATA_API_URL"),
+		"pinata_base_url", pinataBaseURL,
+		"pinata_jwt", redact(os.Getenv("PINATA_JWT")),
+		"pinata_api_key", redact(os.Getenv("PINATA_API_KEY")),
+		"pinata_api_secret", redact(os.Getenv("PINATA_API_SECRET")),
+		"pinata_timeout", pinataTimeout,
+		"pinata_max_retries", pinataMaxRetries,
+		"pinata_max_retry_after", maxRetryAfter,
+		"pinata_max_idle_conns", pinataMaxIdleConns,
+		"pinata_max_idle_conns_per_host", pinataMaxIdleConnsPerHost,
+		"pinata_max_conns_per_host", pinataMaxConnsPerHost,
+		"pinata_idle_conn_timeout", pinataIdleConnTimeout,
+		"pinata_proxy", effectivePinataProxy(cmp.Or(os.Getenv("PINATA_API_URL"), pinataBaseURL)),
+		"pinata_group_id", pinataGroupID,
+		"max_file_size", maxFileSize,
+		"max_per_file_size", maxPerFileSize,
+		"max_request_bytes", maxRequestBytes,
+		"upload_temp_dir", uploadTempDir,
+		"upload_concurrency", uploadConcurrency,
+		"max_files_per_request", maxFilesPerUpload,
+		"ipfs_gateway", ipfsGateway,
+		"normalize_cidv1", normalizeCIDv1,
+		"verify_cid", verifyCID,
+		"strip_exif", stripEXIF,
+		"dry_run", dryRun,
+		"expand_archives", expandArchives,
+		"max_archive_expanded_size", maxArchiveExpandedSize,
+		"cors_allowed_origins", corsAllowedOrigins,
+		"content_security_policy", contentSecurityPolicy,
+		"upload_field_names", uploadFieldNames,
+		"allowed_mime_types", allowedMIMETypes,
+		"blocked_extensions", envList("BLOCKED_EXTENSIONS", nil),
+		"max_image_width", maxImageWidth,
+		"max_image_height", maxImageHeight,
+		"thumbnail_size", thumbnailSize,
+		"clamav_addr", clamavAddr,
+		"clamav_timeout", clamavTimeout,
+		"jwt_secret", redact(string(jwtSecret)),
+		"jwt_ttl", jwtTTL,
+		"api_keys", len(apiKeys),
+		"admin_api_keys", len(adminAPIKeys),
+		"admin_users", adminUsers,
+		"api_key_quotas", len(apiKeyQuotas),
+		"encryption", encryptionAEAD != nil,
+		"webhook_url", redact(webhookURL),
+		"webhook_secret", redact(webhookSecret),
+		"slack_webhook_url", redact(slackWebhookURL),
+		"slack_failure_threshold", slackFailureThreshold,
+		"slack_alert_interval", slackAlertInterval,
+		"circuit_breaker_threshold", pinataBreaker.threshold,
+		"circuit_breaker_window", pinataBreaker.window,
+		"circuit_breaker_cooldown", pinataBreaker.cooldown,
+		"idempotency_ttl", idempotencyTTL,
+		"dedup_cache", dedupCacheEnabled,
+		"dedup_cache_ttl", dedupCacheTTL,
+		"pin_status_cache_ttl", pinStatusCacheTTL,
+		"repin_check_interval", repinCheckInterval,
+		"expiry_check_interval", expiryCheckInterval,
+		"statsd_addr", statsdAddr,
+		"statsd_prefix", statsdPrefix,
+		"statsd_tags", statsdTags,
+		"rate_limit_rps", rateLimitRPS,
+		"rate_limit_burst", rateLimitBurst,
+		"trusted_proxies", trustedProxies,
+		"shutdown_timeout", shutdownTimeout,
+		"otel_exporter_otlp_endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"read_header_timeout", readHeaderTimeout,
+		"read_timeout", readTimeout,
+		"write_timeout", writeTimeout,
+		"idle_timeout", idleTimeout,
+		"request_upload_deadline", requestUploadDeadline,
+		"upload_queue_depth", uploadQueueDepth,
+		"upload_queue_wait", uploadQueueWait,
+		"upload_queue_retry_after", uploadQueueRetryAfter,
+	)
+}
+
+// redact hides a secret value while still showing whether it is set.
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// sizeUnits maps the accepted size suffixes to their multipliers.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize parses a byte size such as "25MB", "512 KB" or "1048576".
+func parseSize(raw string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(raw))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", raw, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("size must be positive, got %d", n)
+	}
+	if n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("size %d overflows", n)
+	}
+
+	return n * multiplier, nil
+}
+
+// envSize reads a byte size from the environment, falling back to def when
+// the variable is unset or cannot be parsed.
+func envSize(key string, def int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	size, err := parseSize(value)
+	if err != nil {
+		logger.Warn("Invalid size, using default", "key", key, "value", value, "default", def, "error", err)
+		return def
+	}
+
+	return size
+}
+
+// envInt reads a non-negative integer from the environment, falling back to
+// def when the variable is unset or invalid.
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		logger.Warn("Invalid integer, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+
+	return n
+}
+
+// envDuration reads a positive duration such as "90s" or "2m" from the
+// environment. Bare integers are treated as seconds.
+func envDuration(key string, def time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		logger.Warn("Invalid duration, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+
+	return d
+}
+
+// envFloat reads a non-negative number from the environment, falling back to
+// def when the variable is unset or invalid.
+func envFloat(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f < 0 {
+		logger.Warn("Invalid number, using default", "key", key, "value", value, "default", def)
+		return def
+	}
+
+	return f
+}
+
+// envList reads a comma-separated list from the environment, trimming
+// whitespace and dropping empty entries. def is returned when unset.
+func envList(key string, def []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePort validates a TCP port number, returning defaultPort when value is
+// empty.
+func parsePort(value string) (int, error) {
+	if value == "" {
+		return defaultPort, nil
+	}
+
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("port must be a number: %w", err)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port %d is out of range 1-65535", port)
+	}
+	return port, nil
+}
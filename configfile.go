@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile lists the non-secret settings that may be kept in the YAML file
+// named by CONFIG_FILE. Credentials and signing secrets are deliberately
+// absent and can only be supplied through the environment.
+type configFile struct {
+	Port               string `yaml:"port"`
+	Host               string `yaml:"host"`
+	ListenAddr         string `yaml:"listen_addr"`
+	PinataTimeout      string `yaml:"pinata_timeout"`
+	URLFetchTimeout    string `yaml:"url_fetch_timeout"`
+	ShutdownTimeout    string `yaml:"shutdown_timeout"`
+	ReadHeaderTimeout  string `yaml:"read_header_timeout"`
+	ReadTimeout        string `yaml:"read_timeout"`
+	WriteTimeout       string `yaml:"write_timeout"`
+	IdleTimeout        string `yaml:"idle_timeout"`
+	UploadConcurrency  string `yaml:"upload_concurrency"`
+	MaxFileSize        string `yaml:"max_file_size"`
+	MaxPerFileSize     string `yaml:"max_per_file_size"`
+	MaxFilesPerRequest string `yaml:"max_files_per_request"`
+	MaxRequestBytes    string `yaml:"max_request_bytes"`
+	IPFSGateway        string `yaml:"ipfs_gateway"`
+}
+
+// configFilePath is the file loaded by loadConfigFile, if any.
+var configFilePath string
+
+// loadConfigFile reads the YAML file named by CONFIG_FILE and exports each
+// setting it contains to the environment unless the variable is already
+// set, so env vars override the file and the rest of the configuration is
+// validated and loaded in one place. Unknown keys are rejected so that a
+// misspelled or secret setting is not silently ignored.
+func loadConfigFile() error {
+	configFilePath = os.Getenv("CONFIG_FILE")
+	if configFilePath == "" {
+		return nil
+	}
+
+	f, err := os.Open(configFilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var cfg configFile
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", configFilePath, err)
+	}
+
+	settings := map[string]string{
+		"PORT":                       cfg.Port,
+		"HOST":                       cfg.Host,
+		"LISTEN_ADDR":                cfg.ListenAddr,
+		"PINATA_TIMEOUT":             cfg.PinataTimeout,
+		"URL_FETCH_TIMEOUT":          cfg.URLFetchTimeout,
+		"SHUTDOWN_TIMEOUT":           cfg.ShutdownTimeout,
+		"SERVER_READ_HEADER_TIMEOUT": cfg.ReadHeaderTimeout,
+		"SERVER_READ_TIMEOUT":        cfg.ReadTimeout,
+		"SERVER_WRITE_TIMEOUT":       cfg.WriteTimeout,
+		"SERVER_IDLE_TIMEOUT":        cfg.IdleTimeout,
+		"UPLOAD_CONCURRENCY":         cfg.UploadConcurrency,
+		"MAX_FILE_SIZE":              cfg.MaxFileSize,
+		"MAX_PER_FILE_SIZE":          cfg.MaxPerFileSize,
+		"MAX_FILES_PER_REQUEST":      cfg.MaxFilesPerRequest,
+		"MAX_REQUEST_BYTES":          cfg.MaxRequestBytes,
+		"IPFS_GATEWAY":               cfg.IPFSGateway,
+	}
+	for key, value := range settings {
+		if value == "" {
+			continue
+		}
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		os.Setenv(key, value)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultDedupCacheTTL = 24 * time.Hour
+
+var (
+	// dedupCacheEnabled turns on the content cache (DEDUP_CACHE=true).
+	dedupCacheEnabled bool
+	// dedupCacheTTL is how long a stored CID is reused for the same content.
+	dedupCacheTTL = defaultDedupCacheTTL
+)
+
+// dedupCache remembers the results of recent uploads by content hash so
+// that uploading the same file again returns the known CID without sending
+// it to the provider. It is nil when the cache is disabled.
+var dedupCache *contentCache
+
+type contentCacheEntry struct {
+	result  UploadResult
+	expires time.Time
+}
+
+// contentCache is an in-memory map of content keys to upload results, with
+// expired entries evicted in the background. A nil cache never hits.
+type contentCache struct {
+	mu      sync.Mutex
+	entries map[string]contentCacheEntry
+	ttl     time.Duration
+}
+
+// newContentCache creates a cache that keeps results for ttl.
+func newContentCache(ttl time.Duration) *contentCache {
+	c := &contentCache{
+		entries: make(map[string]contentCacheEntry),
+		ttl:     ttl,
+	}
+	go c.evictExpired()
+	return c
+}
+
+// evictExpired periodically drops entries past their TTL.
+func (c *contentCache) evictExpired() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mu.Lock()
+		for key, entry := range c.entries {
+			if time.Now().After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// dedupKey identifies content together with everything that decides where
+// and how it is pinned: the same bytes pinned as another CID version, into
+// another group or under another caller's Pinata account are a different
+// pin.
+func dedupKey(ctx context.Context, sum string, opts uploadOptions) string {
+	var group string
+	if opts.Options != nil {
+		group = opts.Options.GroupID
+	}
+	return fmt.Sprintf("%s|v%d|%s|%s", sum, opts.cidVersion(), group, pinataAccountKey(ctx))
+}
+
+// uploadDeduped returns the cached result for content with SHA-256 sum when
+// there is one, and otherwise calls upload and caches its result. cached
+// reports a cache hit, in which case nothing was uploaded.
+func uploadDeduped(ctx context.Context, sum string, opts uploadOptions, upload func() (UploadResult, error)) (result UploadResult, cached bool, err error) {
+	if dedupCache == nil {
+		result, err = upload()
+		return result, false, err
+	}
+
+	key := dedupKey(ctx, sum, opts)
+	if result, ok := dedupCache.get(key); ok {
+		loggerFrom(ctx).Info("Reusing cached upload", "sha256", sum, "cid", result.CID)
+		result.Duration = 0
+		result.Cached = true
+		return result, true, nil
+	}
+
+	result, err = upload()
+	if err == nil {
+		dedupCache.put(key, result)
+	}
+	return result, false, err
+}
+
+func (c *contentCache) get(key string) (UploadResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return UploadResult{}, false
+	}
+	return entry.result, true
+}
+
+func (c *contentCache) put(key string, result UploadResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = contentCacheEntry{result: result, expires: time.Now().Add(c.ttl)}
+}
+
+// forget drops every entry for cid, so content that was unpinned is
+// uploaded again rather than answered with a CID that is no longer pinned.
+func (c *contentCache) forget(cid string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.result.CID == cid {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingProvider is a StorageProvider that counts the uploads it was
+// given.
+type countingProvider struct {
+	StorageProvider
+	uploads *int
+}
+
+func (p countingProvider) Upload(ctx context.Context, filename string, file io.Reader) (UploadResult, error) {
+	*p.uploads++
+	return p.StorageProvider.Upload(ctx, filename, file)
+}
+
+func TestDedupCacheReusesUploads(t *testing.T) {
+	var uploads int
+	prevStorage, prevProvider, prevCache := storage, storageProvider, dedupCache
+	storage, storageProvider, dedupCache = countingProvider{MockProvider{}, &uploads}, "mock", newContentCache(time.Hour)
+	t.Cleanup(func() { storage, storageProvider, dedupCache = prevStorage, prevProvider, prevCache })
+
+	upload := func() UploadResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleUpload(rec, newUploadRequest(t, testFile{"hello.txt", "hello"}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200; body: %s", rec.Code, rec.Body)
+		}
+		var result BatchUploadResponse
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result.SuccessfulUploads[0]
+	}
+
+	first := upload()
+	second := upload()
+	if uploads != 1 {
+		t.Errorf("provider received %d uploads of the same content, want 1", uploads)
+	}
+	if second.IpfsHash != first.IpfsHash || !second.Cached {
+		t.Errorf("second upload = %+v, want the cached CID %s", second, first.IpfsHash)
+	}
+
+	// Once unpinned, the content is uploaded again.
+	recordUnpin(context.Background(), first.IpfsHash)
+	if upload(); uploads != 2 {
+		t.Errorf("provider received %d uploads after the unpin, want 2", uploads)
+	}
+}
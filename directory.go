@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultDirectoryName is the pin name used when directory entries do not
+// share a common top-level folder.
+const defaultDirectoryName = "directory"
+
+// directoryEntry is a file placed at a relative path within a directory
+// upload.
+type directoryEntry struct {
+	fh   *multipart.FileHeader
+	path string
+}
+
+// isDirectoryUpload reports whether any relative path places its file
+// inside a folder.
+func isDirectoryUpload(paths []string) bool {
+	for _, p := range paths {
+		if strings.Contains(p, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanRelativePath normalizes a client-supplied relative path, rejecting
+// absolute paths and any that escape the directory root.
+func cleanRelativePath(p string) (string, error) {
+	if strings.ContainsAny(p, "\x00\\") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+
+	cleaned := path.Clean(p)
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return cleaned, nil
+}
+
+// handleDirectoryUpload validates each file and pins the accepted ones to
+// Pinata as a single directory, responding with the directory CID.
+func handleDirectoryUpload(w http.ResponseWriter, r *http.Request, files []*multipart.FileHeader, paths []string, opts uploadOptions) {
+	result := BatchUploadResponse{
+		SuccessfulUploads: make([]UploadResponse, 0, 1),
+		Errors:            make([]FileError, 0),
+	}
+
+	var entries []directoryEntry
+	for i, fh := range files {
+		relPath, err := cleanRelativePath(paths[i])
+		if err != nil {
+			result.addError(fh.Filename, CodeInvalidFilename, fmt.Sprintf("file %s: %v", fh.Filename, err))
+			continue
+		}
+		if err := validateFile(fh); err != nil {
+			result.addError(fh.Filename, errorCode(err, CodeBadRequest), err.Error())
+			continue
+		}
+		if err := scanFile(r.Context(), fh); err != nil {
+			result.addError(fh.Filename, errorCode(err, CodeScanFailed), err.Error())
+			continue
+		}
+		entries = append(entries, directoryEntry{fh: fh, path: relPath})
+	}
+
+	if len(entries) > 0 {
+		var options PinataOptions
+		if opts.Options != nil {
+			options = *opts.Options
+		}
+		options.WrapWithDirectory = true
+		opts.Options = &options
+
+		provider, _ := storage.(PinataProvider)
+		response, duration, err := uploadDirectoryToPinata(r.Context(), provider.httpClient(), entries, opts)
+		if err != nil {
+			countUpload("error")
+			loggerFrom(r.Context()).Warn("Directory upload failed", "files", len(entries), "error", err)
+			result.addError(directoryName(entries), uploadErrorCode(err), fmt.Sprintf("Error uploading directory: %v", err))
+		} else {
+			countUpload("success")
+			loggerFrom(r.Context()).Info("Directory upload succeeded", "files", len(entries), "cid", response.IpfsHash)
+			recordExpiringUpload(r.Context(), directoryName(entries), int64(response.PinSize), response.IpfsHash, opts.ExpiresAt)
+			chargeQuota(r.Context(), int64(response.PinSize))
+			upload := UploadResponse{
+				PinataResponse: response,
+				GatewayURL:     gatewayURL(response.IpfsHash),
+				DurationMS:     duration.Milliseconds(),
+				CIDv1:          cidV1(response.IpfsHash),
+				GroupID:        options.GroupID,
+			}
+			if !opts.ExpiresAt.IsZero() {
+				upload.ExpiresAt = &opts.ExpiresAt
+			}
+			result.SuccessfulUploads = append(result.SuccessfulUploads, upload)
+		}
+	}
+
+	var totalSize int64
+	if len(result.SuccessfulUploads) > 0 {
+		for _, entry := range entries {
+			totalSize += entry.fh.Size
+		}
+	}
+
+	recordBatchOutcome(r.Context(), result)
+	notifyWebhook(r.Context(), WebhookPayload{
+		RequestID:         requestIDFrom(r.Context()),
+		SuccessfulUploads: result.SuccessfulUploads,
+		Errors:            errorMessages(result.Errors),
+		TotalSize:         totalSize,
+	})
+
+	writeBatchResponse(w, r, result)
+}
+
+// uploadDirectoryToPinata sends all entries in one multipart request with
+// client, using each entry's relative path as its filename so Pinata
+// rebuilds the tree.
+func uploadDirectoryToPinata(ctx context.Context, client *http.Client, entries []directoryEntry, opts uploadOptions) (PinataResponse, time.Duration, error) {
+	defer uploadStarted()()
+
+	timer := startUploadTimer()
+	name := directoryName(entries)
+
+	// Entries are reopened on every attempt, so directory uploads can always
+	// be retried.
+	response, err := postToPinata(ctx, client, name, true, func(writer *multipart.Writer) error {
+		for _, entry := range entries {
+			err := writeDirectoryEntry(writer, entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return writePinataFields(writer, name, opts)
+	})
+	return response, timer.ObserveDuration(), err
+}
+
+// writeDirectoryEntry copies one file into the multipart body. The part
+// header is built by hand because CreateFormFile would escape the slashes
+// Pinata relies on.
+func writeDirectoryEntry(writer *multipart.Writer, entry directoryEntry) error {
+	file, err := entry.fh.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", entry.path, err)
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("failed to read %s: %w", entry.path, err)
+	}
+	head = head[:n]
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, strings.ReplaceAll(entry.path, `"`, "%22")))
+	header.Set("Content-Type", fileContentType(entry.fh.Header.Get("Content-Type"), head))
+
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+
+	_, err = io.Copy(part, io.MultiReader(bytes.NewReader(head), file))
+	if err != nil {
+		return fmt.Errorf("failed to copy file content: %w", err)
+	}
+
+	return nil
+}
+
+// directoryName returns the top-level folder shared by all entries, or
+// defaultDirectoryName when they differ.
+func directoryName(entries []directoryEntry) string {
+	var root string
+	for i, entry := range entries {
+		first, _, found := strings.Cut(entry.path, "/")
+		if !found {
+			return defaultDirectoryName
+		}
+		if i == 0 {
+			root = first
+		} else if first != root {
+			return defaultDirectoryName
+		}
+	}
+	if root == "" {
+		return defaultDirectoryName
+	}
+	return root
+}
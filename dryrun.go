@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// dryRun makes every /upload a dry run, as ?dry_run=true does for a single
+// request.
+var dryRun bool
+
+// isDryRun reports whether r should be validated without uploading
+// anything. Dry runs never reach Pinata.
+func isDryRun(r *http.Request) bool {
+	return dryRun || r.URL.Query().Get("dry_run") == "true"
+}
+
+// simulateUpload returns the result a real upload of fh would have, without
+// sending it anywhere. The CID is computed locally the way IPFS does, so it
+// matches what Pinata would return for the same content and CID version.
+func simulateUpload(fh *multipart.FileHeader, opts uploadOptions) (UploadResult, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// The CID is that of the content that would be pinned, which differs
+	// from the file when metadata is stripped.
+	var content io.Reader = file
+	stripped := false
+	if stripEXIF {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return UploadResult{}, fmt.Errorf("failed to read file: %w", err)
+		}
+		if out, changed, err := stripImageMetadata(data); err == nil {
+			data, stripped = out, changed
+		}
+		content = bytes.NewReader(data)
+	}
+	hashed := newHashingReader(content)
+
+	builder := newUnixFSBuilder(opts.cidVersion())
+	size, err := io.Copy(builder, hashed)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	cid, err := builder.CID()
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	result := UploadResult{
+		Provider:         storageProvider,
+		CID:              cid,
+		Size:             int(size),
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		MetadataStripped: stripped,
+		ContentSHA256:    hashed.Sum(),
+	}
+	if opts.Options != nil {
+		result.GroupID = opts.Options.GroupID
+	}
+	return result, nil
+}
+
+// newDryRunResponse is newUploadResponse for a simulated upload. No
+// redundant copy is made, so the secondary fields are left out.
+func newDryRunResponse(result UploadResult, sha256 string) UploadResponse {
+	response := newUploadResponse(result, sha256)
+	response.SecondaryProvider = ""
+	response.SecondaryCID = ""
+	response.SecondaryError = ""
+	response.CIDMismatch = false
+	response.DryRun = true
+	return response
+}
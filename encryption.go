@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// encryptionKeySize is the AES-256 key length in bytes.
+const encryptionKeySize = 32
+
+// encryptionAEAD seals uploads when ENCRYPTION_KEY is set. It is nil when
+// encryption is disabled.
+var encryptionAEAD cipher.AEAD
+
+// parseEncryptionKey decodes ENCRYPTION_KEY, given as hex or base64, and
+// requires it to be exactly 32 bytes.
+func parseEncryptionKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(value)
+	}
+	if err != nil {
+		return nil, errors.New("must be hex or base64 encoded")
+	}
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("must decode to %d bytes, got %d", encryptionKeySize, len(key))
+	}
+	return key, nil
+}
+
+// newEncryptionAEAD builds the AES-256-GCM cipher for key.
+func newEncryptionAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptContent reads all of r and returns it sealed with AES-256-GCM as
+// nonce || ciphertext || tag. GCM authenticates the whole message, so the
+// content is held in memory; uploads are already bounded by
+// MAX_PER_FILE_SIZE.
+func encryptContent(r io.Reader) ([]byte, error) {
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	nonce := make([]byte, encryptionAEAD.NonceSize(), encryptionAEAD.NonceSize()+len(plaintext)+encryptionAEAD.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return encryptionAEAD.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decryptContent reverses encryptContent.
+func decryptContent(data []byte) ([]byte, error) {
+	nonceSize := encryptionAEAD.NonceSize()
+	if len(data) < nonceSize+encryptionAEAD.Overhead() {
+		return nil, errors.New("content is too short to be encrypted")
+	}
+	return encryptionAEAD.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
+
+// handleDecrypt fetches encrypted content for /decrypt/{cid} from the IPFS
+// gateway and returns the plaintext. Clients that hold ENCRYPTION_KEY can do
+// the same themselves: the first 12 bytes are the GCM nonce and the rest is
+// the ciphertext with its tag appended.
+func handleDecrypt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if encryptionAEAD == nil {
+		sendErrorResponse(w, "Encryption is not enabled", http.StatusNotFound)
+		return
+	}
+
+	cid := strings.TrimPrefix(r.URL.Path, "/decrypt/")
+	if !isPlausibleCID(cid) {
+		sendErrorResponse(w, "Invalid CID", http.StatusBadRequest)
+		return
+	}
+
+	data, err := fetchGatewayContent(r.Context(), cid)
+	if err != nil {
+		var statusErr *remoteStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			sendErrorResponse(w, "Content not found", http.StatusNotFound)
+			return
+		}
+		loggerFrom(r.Context()).Warn("Failed to fetch encrypted content", "cid", cid, "error", err)
+		sendErrorResponse(w, "Failed to fetch content from IPFS gateway", http.StatusBadGateway)
+		return
+	}
+
+	plaintext, err := decryptContent(data)
+	if err != nil {
+		sendErrorResponse(w, "Content could not be decrypted with the configured key", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(plaintext[:min(len(plaintext), sniffLen)]))
+	w.WriteHeader(http.StatusOK)
+	w.Write(plaintext)
+}
+
+// fetchGatewayContent downloads cid from the IPFS gateway, refusing content
+// larger than an encrypted file of MAX_PER_FILE_SIZE could be.
+func fetchGatewayContent(ctx context.Context, cid string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, urlFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gatewayURL(cid), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := gatewayClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &remoteStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	limit := maxPerFileSize + int64(encryptionAEAD.NonceSize()+encryptionAEAD.Overhead())
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errRemoteTooLarge
+	}
+	return data, nil
+}
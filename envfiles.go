@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// defaultEnvFiles are loaded when neither ENV_FILES nor ENV_FILE is set: a
+// committed .env, overridden by a git-ignored .env.local holding local
+// secrets.
+var defaultEnvFiles = []string{".env", ".env.local"}
+
+// loadEnvFiles merges the files named by ENV_FILES, in order, into the
+// environment, with later files overriding earlier ones. Variables already
+// set in the process environment take precedence over every file. Missing
+// files are skipped and returned, except that a single file named by
+// ENV_FILE must exist. Nothing is validated here; the merged result is
+// checked as a whole by validateConfig.
+func loadEnvFiles() (missing []string, err error) {
+	paths := envList("ENV_FILES", nil)
+	required := false
+	if paths == nil {
+		if envFile := os.Getenv("ENV_FILE"); envFile != "" {
+			paths, required = []string{envFile}, true
+		} else {
+			paths = defaultEnvFiles
+		}
+	}
+
+	merged := make(map[string]string)
+	for _, path := range paths {
+		values, err := godotenv.Read(path)
+		if errors.Is(err, fs.ErrNotExist) && !required {
+			missing = append(missing, path)
+			continue
+		}
+		if err != nil {
+			return missing, err
+		}
+		for key, value := range values {
+			merged[key] = value
+		}
+	}
+
+	for key, value := range merged {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		os.Setenv(key, value)
+	}
+	return missing, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadEnvFilesLayersFiles(t *testing.T) {
+	dir := t.TempDir()
+	base, local, absent := filepath.Join(dir, ".env"), filepath.Join(dir, ".env.local"), filepath.Join(dir, ".env.missing")
+	if err := os.WriteFile(base, []byte("ENVTEST_BASE=base\nENVTEST_LOCAL=base\nENVTEST_PROCESS=base\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, []byte("ENVTEST_LOCAL=local\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ENV_FILES", base+","+local+","+absent)
+	t.Setenv("ENVTEST_PROCESS", "process")
+	// Registered with t.Setenv so they are restored, then unset so the files
+	// can set them.
+	for _, key := range []string{"ENVTEST_BASE", "ENVTEST_LOCAL"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	missing, err := loadEnvFiles()
+	if err != nil {
+		t.Fatalf("loadEnvFiles: %v", err)
+	}
+	if !slices.Equal(missing, []string{absent}) {
+		t.Errorf("missing = %v, want [%s]", missing, absent)
+	}
+	for key, want := range map[string]string{
+		"ENVTEST_BASE":    "base",
+		"ENVTEST_LOCAL":   "local",
+		"ENVTEST_PROCESS": "process",
+	} {
+		if got := os.Getenv(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestLoadEnvFilesRequiresEnvFile(t *testing.T) {
+	t.Setenv("ENV_FILES", "")
+	t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+
+	if _, err := loadEnvFiles(); err == nil {
+		t.Error("loadEnvFiles succeeded with a missing ENV_FILE, want an error")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// ErrorCode is a stable, machine-readable identifier for an error, sent
+// alongside the human-readable message so clients can branch on it.
+type ErrorCode string
+
+// Codes derived from the HTTP status when no more specific code applies.
+const (
+	CodeBadRequest          ErrorCode = "BAD_REQUEST"
+	CodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	CodeForbidden           ErrorCode = "FORBIDDEN"
+	CodeNotFound            ErrorCode = "NOT_FOUND"
+	CodeMethodNotAllowed    ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeConflict            ErrorCode = "CONFLICT"
+	CodeRequestTooLarge     ErrorCode = "REQUEST_TOO_LARGE"
+	CodeRangeNotSatisfiable ErrorCode = "RANGE_NOT_SATISFIABLE"
+	CodeUnprocessable       ErrorCode = "UNPROCESSABLE"
+	CodeRateLimited         ErrorCode = "RATE_LIMITED"
+	CodeInternal            ErrorCode = "INTERNAL_ERROR"
+	CodeUpstreamError       ErrorCode = "UPSTREAM_ERROR"
+	CodeUnavailable         ErrorCode = "SERVICE_UNAVAILABLE"
+	CodeInsufficientStorage ErrorCode = "INSUFFICIENT_STORAGE"
+)
+
+// Codes for specific failures.
+const (
+	CodeTooManyFiles       ErrorCode = "TOO_MANY_FILES"
+	CodeInvalidFilename    ErrorCode = "INVALID_FILENAME"
+	CodeBlockedExtension   ErrorCode = "BLOCKED_EXTENSION"
+	CodeEmptyFile          ErrorCode = "EMPTY_FILE"
+	CodeFileTooLarge       ErrorCode = "FILE_TOO_LARGE"
+	CodeInvalidMIME        ErrorCode = "INVALID_MIME"
+	CodeImageTooLarge      ErrorCode = "IMAGE_TOO_LARGE"
+	CodeFileInfected       ErrorCode = "FILE_INFECTED"
+	CodeScanFailed         ErrorCode = "SCAN_FAILED"
+	CodeUploadFailed       ErrorCode = "UPLOAD_FAILED"
+	CodePinataUnavailable  ErrorCode = "PINATA_UNAVAILABLE"
+	CodeQuotaExceeded      ErrorCode = "QUOTA_EXCEEDED"
+	CodeClientDisconnected ErrorCode = "CLIENT_DISCONNECTED"
+	CodeDeadlineExceeded   ErrorCode = "DEADLINE_EXCEEDED"
+	CodeServerBusy         ErrorCode = "SERVER_BUSY"
+	CodeInvalidArchive     ErrorCode = "INVALID_ARCHIVE"
+	CodeArchiveTooLarge    ErrorCode = "ARCHIVE_TOO_LARGE"
+)
+
+// statusErrorCodes maps HTTP statuses to the code sendErrorResponse uses.
+var statusErrorCodes = map[int]ErrorCode{
+	http.StatusBadRequest:                   CodeBadRequest,
+	http.StatusUnauthorized:                 CodeUnauthorized,
+	http.StatusForbidden:                    CodeForbidden,
+	http.StatusNotFound:                     CodeNotFound,
+	http.StatusMethodNotAllowed:             CodeMethodNotAllowed,
+	http.StatusConflict:                     CodeConflict,
+	http.StatusRequestEntityTooLarge:        CodeRequestTooLarge,
+	http.StatusRequestedRangeNotSatisfiable: CodeRangeNotSatisfiable,
+	http.StatusUnprocessableEntity:          CodeUnprocessable,
+	http.StatusTooManyRequests:              CodeRateLimited,
+	http.StatusInternalServerError:          CodeInternal,
+	http.StatusBadGateway:                   CodeUpstreamError,
+	http.StatusServiceUnavailable:           CodeUnavailable,
+	http.StatusInsufficientStorage:          CodeInsufficientStorage,
+}
+
+// statusErrorCode returns the generic code for an HTTP status.
+func statusErrorCode(statusCode int) ErrorCode {
+	if code, ok := statusErrorCodes[statusCode]; ok {
+		return code
+	}
+	if statusCode >= 500 {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+// codedError attaches an ErrorCode to an error whose message is reported to
+// the client.
+type codedError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode returns err tagged with code.
+func withCode(code ErrorCode, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// errorCode returns the code attached to err by withCode, or fallback.
+func errorCode(err error, fallback ErrorCode) ErrorCode {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return fallback
+}
+
+// uploadErrorCode classifies an error returned by a storage provider.
+func uploadErrorCode(err error) ErrorCode {
+	if errors.Is(err, errCircuitOpen) {
+		return CodePinataUnavailable
+	}
+	var statusErr *pinataStatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests {
+			return CodePinataUnavailable
+		}
+		return CodeUploadFailed
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return CodePinataUnavailable
+	}
+	return errorCode(err, CodeUploadFailed)
+}
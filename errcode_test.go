@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadErrorCodes(t *testing.T) {
+	useMockStorage(t)
+	prevMaxFiles := maxFilesPerUpload
+	maxFilesPerUpload = 1
+	t.Cleanup(func() { maxFilesPerUpload = prevMaxFiles })
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   ErrorCode
+	}{
+		{
+			name:   "wrong method",
+			req:    httptest.NewRequest(http.MethodGet, "/upload", nil),
+			status: http.StatusMethodNotAllowed,
+			code:   CodeMethodNotAllowed,
+		},
+		{
+			name:   "not multipart",
+			req:    httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("hello")),
+			status: http.StatusBadRequest,
+			code:   CodeBadRequest,
+		},
+		{
+			name:   "too many files",
+			req:    newUploadRequest(t, testFile{"a.txt", "a"}, testFile{"b.txt", "b"}),
+			status: http.StatusBadRequest,
+			code:   CodeTooManyFiles,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleUpload(rec, tt.req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.code || body.Error == "" {
+				t.Errorf("body = %+v, want code %s with a message", body, tt.code)
+			}
+		})
+	}
+}
+
+func TestUploadErrorCodeClassification(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCode
+	}{
+		{&pinataStatusError{StatusCode: http.StatusBadRequest}, CodeUploadFailed},
+		{&pinataStatusError{StatusCode: http.StatusTooManyRequests}, CodePinataUnavailable},
+		{fmt.Errorf("upload: %w", &pinataStatusError{StatusCode: http.StatusBadGateway}), CodePinataUnavailable},
+		{errCircuitOpen, CodePinataUnavailable},
+		{fmt.Errorf("scan: %w", withCode(CodeFileInfected, errors.New("infected"))), CodeFileInfected},
+		{errors.New("something else"), CodeUploadFailed},
+	}
+	for _, tt := range tests {
+		if got := uploadErrorCode(tt.err); got != tt.want {
+			t.Errorf("uploadErrorCode(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// stripEXIF enables removing EXIF and similar metadata from JPEG and TIFF
+// uploads before they are pinned. It changes the content, and so the CID,
+// of any file that carried metadata.
+var stripEXIF bool
+
+// JPEG markers that carry metadata rather than image data: APP1 (EXIF and
+// XMP), APP12, APP13 (IPTC) and comments. ICC profiles (APP2) and the
+// Adobe colour transform (APP14) affect how pixels are decoded, so they are
+// kept.
+var jpegMetadataMarkers = map[byte]bool{0xE1: true, 0xEC: true, 0xED: true, 0xFE: true}
+
+// TIFF tags removed from every IFD: descriptive strings identifying the
+// device, software and author, and the pointers to XMP, IPTC, Photoshop,
+// EXIF and GPS data.
+var tiffMetadataTags = map[uint16]bool{
+	270: true, 271: true, 272: true, 305: true, 306: true, 315: true, 316: true,
+	700: true, 33723: true, 34377: true, tiffExifIFDTag: true, tiffGPSIFDTag: true,
+}
+
+const (
+	tiffExifIFDTag    = 34665
+	tiffGPSIFDTag     = 34853
+	tiffInteropIFDTag = 40965
+)
+
+// uploadStripped is uploadContent for content that may be an image, removing
+// its metadata first when stripEXIF is set. Content that is not a JPEG or
+// TIFF, or that cannot be parsed, is uploaded unchanged.
+func uploadStripped(ctx context.Context, filename string, r io.ReadSeeker, opts uploadOptions) (UploadResult, error) {
+	if !stripEXIF {
+		return uploadContent(ctx, filename, r, opts)
+	}
+
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(r, magic)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	if !isJPEG(magic[:n]) && !isTIFF(magic[:n]) {
+		return uploadContent(ctx, filename, r, opts)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	stripped, changed, err := stripImageMetadata(data)
+	if err != nil {
+		loggerFrom(ctx).Warn("Could not strip image metadata, uploading unchanged", "filename", filename, "error", err)
+		return uploadContent(ctx, filename, bytes.NewReader(data), opts)
+	}
+
+	result, err := uploadContent(ctx, filename, bytes.NewReader(stripped), opts)
+	result.MetadataStripped = changed
+	return result, err
+}
+
+// stripImageMetadata returns data without its metadata, reporting whether
+// anything was removed.
+func stripImageMetadata(data []byte) ([]byte, bool, error) {
+	switch {
+	case isJPEG(data):
+		return stripJPEGMetadata(data)
+	case isTIFF(data):
+		return stripTIFFMetadata(data)
+	default:
+		return data, false, nil
+	}
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF
+}
+
+func isTIFF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))
+}
+
+// stripJPEGMetadata copies data leaving out metadata segments. Everything
+// from the start of scan on, which holds the compressed pixels, is copied
+// verbatim.
+func stripJPEGMetadata(data []byte) ([]byte, bool, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	changed := false
+
+	i := 2
+	for {
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return nil, false, errors.New("malformed JPEG: expected a marker")
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// Fill byte before a marker.
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Start of scan or end of image.
+			out = append(out, data[i:]...)
+			return out, changed, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			// Markers without a length.
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, false, errors.New("malformed JPEG: truncated segment")
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, false, errors.New("malformed JPEG: truncated segment")
+		}
+		if jpegMetadataMarkers[marker] {
+			changed = true
+		} else {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// tiffFile is a classic TIFF being edited in place.
+type tiffFile struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// stripTIFFMetadata removes metadata tags from every IFD of a TIFF. The file
+// is edited in place, keeping every offset valid: entries are dropped from
+// their IFD and the values they pointed to are zeroed. Image data is never
+// moved.
+func stripTIFFMetadata(data []byte) ([]byte, bool, error) {
+	if len(data) < 8 {
+		return nil, false, errors.New("malformed TIFF: truncated header")
+	}
+	t := &tiffFile{data: bytes.Clone(data), order: binary.LittleEndian}
+	if data[0] == 'M' {
+		t.order = binary.BigEndian
+	}
+
+	changed := false
+	visited := make(map[uint32]bool)
+	for offset := t.order.Uint32(t.data[4:]); offset != 0; {
+		if visited[offset] {
+			return nil, false, errors.New("malformed TIFF: IFD loop")
+		}
+		visited[offset] = true
+
+		next, removed, err := t.stripIFD(offset)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = changed || removed
+		offset = next
+	}
+	return t.data, changed, nil
+}
+
+// stripIFD removes metadata entries from the IFD at offset, returning the
+// offset of the next IFD.
+func (t *tiffFile) stripIFD(offset uint32) (uint32, bool, error) {
+	start, count, err := t.ifd(offset)
+	if err != nil {
+		return 0, false, err
+	}
+
+	kept := 0
+	for i := 0; i < count; i++ {
+		entry := t.data[start+12*i : start+12*i+12]
+		tag := t.order.Uint16(entry)
+		if !tiffMetadataTags[tag] {
+			copy(t.data[start+12*kept:], entry)
+			kept++
+			continue
+		}
+		if err := t.zeroValue(entry, 0); err != nil {
+			return 0, false, err
+		}
+	}
+
+	next := t.order.Uint32(t.data[start+12*count:])
+	if kept == count {
+		return next, false, nil
+	}
+	t.order.PutUint16(t.data[start-2:], uint16(kept))
+	t.order.PutUint32(t.data[start+12*kept:], next)
+	clear(t.data[start+12*kept+4 : start+12*count+4])
+	return next, true, nil
+}
+
+// ifd returns the position of the first entry and the entry count of the
+// IFD at offset, checking that it lies within the file.
+func (t *tiffFile) ifd(offset uint32) (int, int, error) {
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return 0, 0, errors.New("malformed TIFF: IFD out of range")
+	}
+	count := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	if start+12*count+4 > len(t.data) {
+		return 0, 0, errors.New("malformed TIFF: IFD out of range")
+	}
+	return start, count, nil
+}
+
+// zeroValue clears the value an entry points to. Entries pointing to a
+// sub-IFD, such as the EXIF and GPS IFDs, have that IFD and its values
+// cleared too.
+func (t *tiffFile) zeroValue(entry []byte, depth int) error {
+	tag := t.order.Uint16(entry)
+	typ := t.order.Uint16(entry[2:])
+	count := t.order.Uint32(entry[4:])
+
+	if tag == tiffExifIFDTag || tag == tiffGPSIFDTag || tag == tiffInteropIFDTag {
+		if depth > 2 {
+			return errors.New("malformed TIFF: nested IFDs")
+		}
+		offset := t.order.Uint32(entry[8:])
+		start, n, err := t.ifd(offset)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := t.zeroValue(t.data[start+12*i:start+12*i+12], depth+1); err != nil {
+				return err
+			}
+		}
+		clear(t.data[offset : start+12*n+4])
+		return nil
+	}
+
+	size, ok := tiffTypeSizes[typ]
+	if !ok {
+		return fmt.Errorf("malformed TIFF: unknown type %d for tag %d", typ, tag)
+	}
+	total := uint64(size) * uint64(count)
+	if total <= 4 {
+		// The value is stored in the entry itself.
+		return nil
+	}
+	offset := uint64(t.order.Uint32(entry[8:]))
+	if offset+total > uint64(len(t.data)) {
+		return errors.New("malformed TIFF: value out of range")
+	}
+	clear(t.data[offset : offset+total])
+	return nil
+}
+
+// tiffTypeSizes maps TIFF field types to their size in bytes.
+var tiffTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultExpiryCheckInterval = time.Minute
+
+// expiryCheckInterval is how often pins with a ttl are checked for expiry.
+var expiryCheckInterval = defaultExpiryCheckInterval
+
+// parseTTL reads the ttl form field, accepting either a Go duration such as
+// "24h" or a bare number of seconds.
+func parseTTL(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if n, err := strconv.Atoi(raw); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("must be positive")
+		}
+		return time.Duration(n) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("must be a duration such as 24h or a number of seconds")
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
+
+// runExpiryChecks periodically unpins recorded uploads whose ttl has
+// passed, until ctx is done. Expiries are kept in the upload store, so pins
+// that expire while the server is down are removed on the first pass.
+func runExpiryChecks(ctx context.Context, interval time.Duration) {
+	unpinExpired(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			unpinExpired(ctx)
+		}
+	}
+}
+
+// unpinExpired unpins every CID whose expiry has passed. Failed unpins keep
+// their expiry and are retried on the next pass.
+func unpinExpired(ctx context.Context) {
+	cids, err := store.expired(ctx, time.Now())
+	if err != nil {
+		logger.Error("Expiry check could not read expiries", "error", err)
+		return
+	}
+
+	for _, cid := range cids {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := unpinFromPinata(ctx, cid)
+		if err != nil && !errors.Is(err, errNotPinned) {
+			expiredUnpinsTotal.WithLabelValues("error").Inc()
+			logger.Warn("Failed to unpin expired CID", "cid", cid, "error", err)
+			continue
+		}
+		expiredUnpinsTotal.WithLabelValues("success").Inc()
+		recordUnpin(ctx, cid)
+		logger.Info("Unpinned expired CID", "cid", cid)
+	}
+}
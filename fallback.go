@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// fallbackStorageProvider names the provider selected by FALLBACK_PROVIDER,
+// and fallbackStorage is its implementation. Uploads the primary provider
+// cannot take are sent there instead when it is set.
+var (
+	fallbackStorageProvider string
+	fallbackStorage         StorageProvider
+)
+
+// uploadWithFallback stores data with the primary provider, retrying it
+// against the fallback provider when the primary is unavailable. The result
+// records which provider stored the file.
+func uploadWithFallback(ctx context.Context, filename string, data []byte) (UploadResult, error) {
+	result, err := storage.Upload(ctx, filename, bytes.NewReader(data))
+	result.Provider = storageProvider
+	if err == nil || fallbackStorage == nil || !shouldFallBack(ctx, err) {
+		return result, err
+	}
+
+	loggerFrom(ctx).Warn("Primary provider failed, falling back", "filename", filename, "provider", storageProvider, "fallback_provider", fallbackStorageProvider, "error", err)
+	result, fallbackErr := fallbackStorage.Upload(ctx, filename, bytes.NewReader(data))
+	result.Provider = fallbackStorageProvider
+	if fallbackErr != nil {
+		fallbacksTotal.WithLabelValues("error").Inc()
+		loggerFrom(ctx).Error("Fallback provider failed", "filename", filename, "fallback_provider", fallbackStorageProvider, "error", fallbackErr)
+		return UploadResult{}, fmt.Errorf("%w; fallback provider %s: %w", err, fallbackStorageProvider, fallbackErr)
+	}
+	fallbacksTotal.WithLabelValues("success").Inc()
+	loggerFrom(ctx).Info("Upload stored with fallback provider", "filename", filename, "fallback_provider", fallbackStorageProvider, "cid", result.CID)
+	return result, nil
+}
+
+// shouldFallBack reports whether err means the primary provider is
+// unavailable, as opposed to the request itself being unacceptable or the
+// client having gone away.
+func shouldFallBack(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, errCircuitOpen) {
+		return true
+	}
+
+	var statusErr *pinataStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < 500 {
+		// Rejected credentials and rate limits are the provider's problem;
+		// other 4xx responses would be rejected by any provider.
+		switch statusErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// gatewayResponseHeaderTimeout bounds how long the IPFS gateway may take to
+// start answering, which includes it fetching uncached content from the
+// network.
+const gatewayResponseHeaderTimeout = 60 * time.Second
+
+// gatewayClient talks to the IPFS gateway. It has no overall timeout, since
+// proxied content is streamed and may be large, but a gateway that stalls
+// while connecting or before responding is given up on.
+var gatewayClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: gatewayResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	},
+}
+
+// gatewayRequestHeaders are forwarded from the client to the IPFS gateway so
+// that range requests and cache revalidation work through the proxy.
+var gatewayRequestHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+// gatewayResponseHeaders are copied from the gateway response to the client.
+var gatewayResponseHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Accept-Ranges",
+	"Content-Disposition",
+	"Cache-Control",
+	"ETag",
+	"Last-Modified",
+}
+
+// handleGatewayProxy streams pinned content for /ipfs/{cid}[/path] from the
+// configured IPFS gateway, so browsers can load it without talking to the
+// gateway directly.
+func handleGatewayProxy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentPath := strings.TrimPrefix(r.URL.Path, "/ipfs/")
+	cid, _, _ := strings.Cut(contentPath, "/")
+	if !isPlausibleCID(cid) {
+		sendErrorResponse(w, "Invalid CID", http.StatusBadRequest)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, gatewayURL(contentPath), nil)
+	if err != nil {
+		sendErrorResponse(w, "Invalid CID", http.StatusBadRequest)
+		return
+	}
+	for _, key := range gatewayRequestHeaders {
+		if value := r.Header.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := gatewayClient.Do(req)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("Gateway request failed", "cid", cid, "error", err)
+		sendErrorResponse(w, "Failed to reach IPFS gateway", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+	case http.StatusNotFound:
+		sendErrorResponse(w, "Content not found", http.StatusNotFound)
+		return
+	default:
+		loggerFrom(r.Context()).Warn("Gateway returned an error", "cid", cid, "status", resp.Status)
+		sendErrorResponse(w, fmt.Sprintf("IPFS gateway returned %s", resp.Status), http.StatusBadGateway)
+		return
+	}
+
+	for _, key := range gatewayResponseHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if r.Method == http.MethodGet {
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			loggerFrom(r.Context()).Debug("Gateway response copy ended early", "cid", cid, "error", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+)
+
+// fileSHA256 returns the hex-encoded SHA-256 of an uploaded file's content.
+// The file is reopened for the actual upload, so reading it here is safe.
+func fileSHA256(fh *multipart.FileHeader) (string, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// bytesSHA256 returns the hex-encoded SHA-256 of data.
+func bytesSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashingReader computes the SHA-256 of everything read through it, so
+// content can be hashed while it is streamed elsewhere instead of being
+// buffered or read a second time.
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+// reader returns r as an io.ReadSeeker when the content it wraps can be
+// rewound, so providers can still replay it on a retry.
+func (r *hashingReader) reader() io.Reader {
+	if seeker, ok := r.r.(io.Seeker); ok {
+		return &hashingReadSeeker{hashingReader: r, seeker: seeker}
+	}
+	return r
+}
+
+// hashingReadSeeker is a hashingReader over rewindable content. Only
+// seeking back to the start is supported, which also restarts the hash.
+type hashingReadSeeker struct {
+	*hashingReader
+	seeker io.Seeker
+}
+
+func (r *hashingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("hashing reader can only seek to the start")
+	}
+	pos, err := r.seeker.Seek(0, io.SeekStart)
+	if err != nil {
+		return pos, err
+	}
+	r.h.Reset()
+	return pos, nil
+}
+
+// Sum returns the hex-encoded SHA-256 of the content read so far.
+func (r *hashingReader) Sum() string {
+	return hex.EncodeToString(r.h.Sum(nil))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helloSHA256 is the SHA-256 of "hello".
+const helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestUploadReportsContentSHA256(t *testing.T) {
+	var received string
+	prevStorage, prevProvider := storage, storageProvider
+	storage, storageProvider = recordingProvider{MockProvider{}, &received}, "mock"
+	t.Cleanup(func() { storage, storageProvider = prevStorage, prevProvider })
+
+	rec := httptest.NewRecorder()
+	handleUpload(rec, newUploadRequest(t, testFile{"hello.txt", "hello"}))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var result BatchUploadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.SuccessfulUploads) != 1 {
+		t.Fatalf("successful_uploads = %+v, want one", result.SuccessfulUploads)
+	}
+	if got := result.SuccessfulUploads[0].ContentSHA256; got != helloSHA256 {
+		t.Errorf("content_sha256 = %s, want %s", got, helloSHA256)
+	}
+	if received != "hello" {
+		t.Errorf("provider received %q, want the whole file", received)
+	}
+}
+
+// recordingProvider is a StorageProvider that keeps a copy of the content it
+// was given.
+type recordingProvider struct {
+	StorageProvider
+	received *string
+}
+
+func (p recordingProvider) Upload(ctx context.Context, filename string, file io.Reader) (UploadResult, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	*p.received = string(data)
+	return p.StorageProvider.Upload(ctx, filename, bytes.NewReader(data))
+}
+
+func TestUploadContentRetriesWhileHashing(t *testing.T) {
+	setPinataTestConfig(t, 1, time.Minute)
+
+	// Each attempt must carry the whole file, including the one sent after
+	// the content was rewound.
+	var received []string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		file, err := multipartFile(req)
+		if err != nil {
+			return nil, err
+		}
+		received = append(received, file)
+		if len(received) == 1 {
+			return replyStatus(http.StatusServiceUnavailable)(req)
+		}
+		return pinataReply(http.StatusOK, pinataOK), nil
+	})}
+	prevStorage, prevProvider := storage, storageProvider
+	storage, storageProvider = PinataProvider{Client: client}, "pinata"
+	t.Cleanup(func() { storage, storageProvider = prevStorage, prevProvider })
+
+	result, err := uploadContent(context.Background(), "hello.txt", strings.NewReader("hello"), uploadOptions{})
+	if err != nil {
+		t.Fatalf("uploadContent: %v", err)
+	}
+	if !slices.Equal(received, []string{"hello", "hello"}) {
+		t.Errorf("Pinata received %q, want the whole file on both attempts", received)
+	}
+	// The hash restarts with the retry rather than covering both attempts.
+	if result.ContentSHA256 != helloSHA256 {
+		t.Errorf("content_sha256 = %s, want %s", result.ContentSHA256, helloSHA256)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader  = "Idempotency-Key"
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+// idempotencyTTL is how long a completed response is replayed for its key.
+var idempotencyTTL = defaultIdempotencyTTL
+
+// idempotency caches /upload responses by Idempotency-Key.
+var idempotency *idempotencyStore
+
+// idempotencyEntry is the state of one key. Until done is set the original
+// request is still running.
+type idempotencyEntry struct {
+	fingerprint string
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore is an in-memory map of keys to responses, with expired
+// entries evicted in the background.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	ttl     time.Duration
+}
+
+// newIdempotencyStore creates a store that keeps responses for ttl.
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	s := &idempotencyStore{
+		entries: make(map[string]*idempotencyEntry),
+		ttl:     ttl,
+	}
+	go s.evictExpired()
+	return s
+}
+
+// evictExpired periodically drops completed entries past their TTL.
+func (s *idempotencyStore) evictExpired() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if entry.done && time.Now().After(entry.expires) {
+				delete(s.entries, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// begin claims key for a request whose parsed form has the given payload.
+// Keys are scoped to the caller and the Pinata account, so one caller's key
+// never replays another's response. If the key already has a response for
+// the same payload it is replayed; a different payload, or a request still
+// in flight under the key, is a 409. In both cases ok is false and the
+// response has been written. Otherwise the returned recorder must be used
+// for the response and finished once the handler is done.
+func (s *idempotencyStore) begin(w http.ResponseWriter, r *http.Request, key string) (rec *idempotencyRecorder, ok bool) {
+	fingerprint, err := requestFingerprint(r.URL.Query(), r.MultipartForm)
+	if err != nil {
+		sendErrorResponse(w, "Failed to read uploaded files: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	key = principalFrom(r.Context()) + "|" + pinataAccountKey(r.Context()) + "|" + key
+
+	s.mu.Lock()
+	entry, exists := s.entries[key]
+	if exists && entry.done && time.Now().After(entry.expires) {
+		exists = false
+	}
+	if !exists {
+		entry = &idempotencyEntry{fingerprint: fingerprint}
+		s.entries[key] = entry
+	}
+	s.mu.Unlock()
+
+	if !exists {
+		return &idempotencyRecorder{ResponseWriter: w, store: s, key: key, entry: entry}, true
+	}
+
+	switch {
+	case entry.fingerprint != fingerprint:
+		sendErrorResponse(w, "Idempotency-Key was already used with a different payload", http.StatusConflict)
+	case !entry.done:
+		sendErrorResponse(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+	default:
+		loggerFrom(r.Context()).Info("Replaying idempotent response", "status", entry.status)
+		w.Header().Set("Content-Type", entry.contentType)
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(entry.status)
+		w.Write(entry.body)
+	}
+	return nil, false
+}
+
+// idempotencyRecorder passes a response through to the client while keeping
+// a copy for later replay.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	store  *idempotencyStore
+	key    string
+	entry  *idempotencyEntry
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// Flush lets streamed NDJSON results reach the client as they are written.
+func (rec *idempotencyRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// finish stores the recorded response. Server errors are not cached, so the
+// key is released and the client can retry the upload.
+func (rec *idempotencyRecorder) finish() {
+	rec.store.mu.Lock()
+	defer rec.store.mu.Unlock()
+
+	if rec.status == 0 || rec.status >= 500 {
+		delete(rec.store.entries, rec.key)
+		return
+	}
+
+	rec.entry.done = true
+	rec.entry.status = rec.status
+	rec.entry.contentType = rec.Header().Get("Content-Type")
+	rec.entry.body = rec.body.Bytes()
+	rec.entry.expires = time.Now().Add(rec.store.ttl)
+}
+
+// requestFingerprint hashes the query parameters, the form values and the
+// name and content of every file, so that a retried request can be told
+// apart from a different one reusing the same key.
+func requestFingerprint(query url.Values, form *multipart.Form) (string, error) {
+	h := sha256.New()
+
+	for _, key := range sortedKeys(query) {
+		for _, value := range query[key] {
+			fmt.Fprintf(h, "query %q=%q\n", key, value)
+		}
+	}
+	for _, key := range sortedKeys(form.Value) {
+		for _, value := range form.Value[key] {
+			fmt.Fprintf(h, "value %q=%q\n", key, value)
+		}
+	}
+	for _, key := range sortedKeys(form.File) {
+		for _, fh := range form.File[key] {
+			sum, err := fileSHA256(fh)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "file %q=%q %d %s\n", key, fh.Filename, fh.Size, sum)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixSocketPrefix marks a LISTEN_ADDR naming a Unix domain socket, as in
+// "unix:/run/fileupload.sock".
+const unixSocketPrefix = "unix:"
+
+// defaultUnixSocketMode lets the owner and group, such as a reverse proxy
+// sharing the group, connect to the socket.
+const defaultUnixSocketMode fs.FileMode = 0o660
+
+// unixSocketMode is the permission set on the socket file.
+var unixSocketMode = defaultUnixSocketMode
+
+// unixSocketPath returns the socket path named by addr, if it names one.
+func unixSocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, unixSocketPrefix)
+	return path, ok
+}
+
+// validateListenAddr checks a LISTEN_ADDR value.
+func validateListenAddr(addr string) error {
+	if path, ok := unixSocketPath(addr); ok {
+		if path == "" {
+			return errors.New("socket path is empty")
+		}
+		return nil
+	}
+	_, _, err := net.SplitHostPort(addr)
+	return err
+}
+
+// parseSocketMode parses an octal permission such as "660".
+func parseSocketMode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal permission such as 660", value)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// listen opens the server's listener: a Unix socket when addr has the unix:
+// prefix, TCP otherwise. The socket file is removed when the listener is
+// closed, which server.Shutdown does.
+func listen(addr string) (net.Listener, error) {
+	path, ok := unixSocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, unixSocketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a server that did
+// not shut down cleanly. Other files, and sockets another process is still
+// listening on, are left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
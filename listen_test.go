@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenUnixSocket(t *testing.T) {
+	prevMode := unixSocketMode
+	unixSocketMode = 0o600
+	t.Cleanup(func() { unixSocketMode = prevMode })
+
+	path := filepath.Join(t.TempDir(), "server.sock")
+	listener, err := listen(unixSocketPrefix + path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket file mode = %v, want a socket with permissions 0600", info.Mode())
+	}
+
+	// A second server may not take over a socket that is still served.
+	if _, err := listen(unixSocketPrefix + path); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("listen on a socket in use = %v, want an already in use error", err)
+	}
+
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file after close: %v, want it removed", err)
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+
+	// A listener that does not unlink its socket on close leaves the file
+	// behind, as a server that crashed would.
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("stale socket was not left behind: %v", err)
+	}
+
+	listener, err := listen(unixSocketPrefix + path)
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	listener.Close()
+}
+
+func TestListenKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listen(unixSocketPrefix + path); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("listen over a regular file = %v, want a not a socket error", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("regular file after listen = %q, %v, want it untouched", data, err)
+	}
+}
@@ -2,21 +2,68 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	maxFileSize = 10 << 20 // 10 MB
+	defaultMaxFileSize       = 10 << 20  // 10 MB
+	defaultMaxRequestBytes   = 100 << 20 // 100 MB
+	defaultPinataMaxRetries  = 3
+	defaultPinataTimeout     = 60 * time.Second
+	defaultUploadConcurrency = 8
+	defaultMaxFilesPerUpload = 100
+	defaultIPFSGateway       = "https://gateway.pinata.cloud/ipfs/"
+	defaultPinataBaseURL     = "https://api.pinata.cloud"
+	defaultPinListLimit      = 10
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 10 * time.Minute
+	defaultWriteTimeout      = 15 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+	defaultPort              = 9000
+	defaultRateLimitRPS      = 10
+	defaultRateLimitBurst    = 20
+	maxPinListLimit          = 1000
+	minCIDLength             = 32
+	maxCIDLength             = 128
+)
+
+var (
+	maxFileSize     int64 = defaultMaxFileSize
+	maxPerFileSize  int64 = defaultMaxFileSize
+	maxRequestBytes int64 = defaultMaxRequestBytes
+
+	pinataMaxRetries = defaultPinataMaxRetries
+	pinataTimeout    = defaultPinataTimeout
+
+	uploadConcurrency = defaultUploadConcurrency
+	maxFilesPerUpload = defaultMaxFilesPerUpload
+	ipfsGateway       = defaultIPFSGateway
+	pinataBaseURL     = defaultPinataBaseURL
+	pinataGroupID     string
+
+	corsAllowedOrigins = []string{"*"}
+	uploadFieldNames   = []string{"files", "file"}
 )
 
 type PinataResponse struct {
@@ -25,8 +72,154 @@ type PinataResponse struct {
 	Timestamp string `json:"Timestamp"`
 }
 
+// UploadResponse is a successful upload as reported to clients.
+type UploadResponse struct {
+	PinataResponse
+	// Provider names the storage provider that holds the file, which
+	// differs from STORAGE_PROVIDER after a fallback.
+	Provider   string `json:"provider,omitempty"`
+	GatewayURL string `json:"gateway_url"`
+	SHA256     string `json:"sha256,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Encrypted  bool   `json:"encrypted,omitempty"`
+	CIDv1      string `json:"cid_v1,omitempty"`
+	GroupID    string `json:"group_id,omitempty"`
+	// ExpiresAt is when the CID will be unpinned, for uploads with a ttl.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// The secondary fields describe the redundant copy, when
+	// SECONDARY_PROVIDER is set. SecondaryError is a warning unless
+	// REQUIRE_BOTH is set, in which case the upload fails instead.
+	SecondaryProvider string `json:"secondary_provider,omitempty"`
+	SecondaryCID      string `json:"secondary_cid,omitempty"`
+	SecondaryError    string `json:"secondary_error,omitempty"`
+	CIDMismatch       bool   `json:"cid_mismatch,omitempty"`
+	// ThumbnailHash is the CID of the thumbnail pinned for an image when
+	// THUMBNAIL_SIZE is set. ThumbnailError explains why an image got none.
+	ThumbnailHash  string `json:"thumbnail_hash,omitempty"`
+	ThumbnailError string `json:"thumbnail_error,omitempty"`
+	// MetadataStripped reports that EXIF or other metadata was removed from
+	// the image before pinning, so the CID is not that of the file as
+	// uploaded. SHA256 still is.
+	MetadataStripped bool `json:"metadata_stripped,omitempty"`
+	// ContentSHA256 is the SHA-256 of the content actually pinned, computed
+	// by this server while streaming it to the provider. It differs from
+	// SHA256 when metadata was stripped, and is taken before encryption.
+	ContentSHA256 string `json:"content_sha256,omitempty"`
+	// DryRun marks a simulated upload: the file was validated and its CID
+	// computed, but nothing was pinned.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Cached marks an upload answered from the dedup cache: the content
+	// was already pinned, so it was not sent to the provider again.
+	Cached bool `json:"cached,omitempty"`
+	// ArchiveMembers maps the path of each file pinned from an expanded
+	// archive to its CID. The archive itself is not pinned, so IpfsHash is
+	// empty and PinSize totals the members. ArchiveErrors holds the
+	// members that were not pinned and why.
+	ArchiveMembers map[string]string `json:"archive_members,omitempty"`
+	ArchiveErrors  map[string]string `json:"archive_errors,omitempty"`
+}
+
+// BatchUploadResponse is the body returned by /upload. The count and size
+// totals are always present, and zero when nothing succeeded.
+type BatchUploadResponse struct {
+	SuccessfulUploads []UploadResponse `json:"successful_uploads"`
+	Errors            []FileError      `json:"errors,omitempty"`
+	TotalPinSize      int64            `json:"total_pin_size"`
+	SuccessfulCount   int              `json:"successful_count"`
+	FailedCount       int              `json:"failed_count"`
+}
+
+// FileError describes why one file in a batch was not uploaded.
+type FileError struct {
+	Filename string    `json:"filename"`
+	Code     ErrorCode `json:"code"`
+	Message  string    `json:"message"`
+}
+
+// legacyBatchUploadResponse is BatchUploadResponse with errors reported as
+// plain messages, as they were before FileError, for clients that pass
+// ?legacy_errors=true.
+type legacyBatchUploadResponse struct {
+	BatchUploadResponse
+	Errors []string `json:"errors,omitempty"`
+}
+
+// PinataMetadata is the optional pinataMetadata attached to each upload.
+type PinataMetadata struct {
+	Name      string         `json:"name,omitempty"`
+	KeyValues map[string]any `json:"keyvalues,omitempty"`
+}
+
+// PinataOptions is the optional pinataOptions attached to each upload. Nil
+// fields are omitted so Pinata applies its defaults.
+type PinataOptions struct {
+	CIDVersion        *int   `json:"cidVersion,omitempty"`
+	WrapWithDirectory bool   `json:"wrapWithDirectory,omitempty"`
+	GroupID           string `json:"groupId,omitempty"`
+}
+
+// uploadJob is a validated file queued for upload.
+type uploadJob struct {
+	index  int
+	fh     *multipart.FileHeader
+	sha256 string
+}
+
+// uploadOptions holds the per-request settings applied to every file in a
+// batch.
+type uploadOptions struct {
+	Metadata *PinataMetadata
+	Options  *PinataOptions
+	// ExpiresAt is when the uploads should be unpinned, or zero to keep
+	// them pinned.
+	ExpiresAt time.Time
+	// ContentType is the type the client declared for the file, if any.
+	ContentType string
+}
+
+// cidVersion returns the CID version requested for the uploads, defaulting
+// to Pinata's CIDv0.
+func (o uploadOptions) cidVersion() int {
+	if o.Options != nil && o.Options.CIDVersion != nil {
+		return *o.Options.CIDVersion
+	}
+	return 0
+}
+
+// withName returns a copy of o that pins uploads under name, keeping any
+// other metadata.
+func (o uploadOptions) withName(name string) uploadOptions {
+	var metadata PinataMetadata
+	if o.Metadata != nil {
+		metadata = *o.Metadata
+	}
+	metadata.Name = name
+	o.Metadata = &metadata
+	return o
+}
+
+// withGroup returns a copy of o that assigns uploads to the Pinata group
+// groupID.
+func (o uploadOptions) withGroup(groupID string) uploadOptions {
+	var options PinataOptions
+	if o.Options != nil {
+		options = *o.Options
+	}
+	options.GroupID = groupID
+	o.Options = &options
+	return o
+}
+
+// UnpinResponse reports the outcome of a successful unpin.
+type UnpinResponse struct {
+	CID    string `json:"cid"`
+	Status string `json:"status"`
+}
+
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error     string    `json:"error"`
+	Code      ErrorCode `json:"code"`
+	RequestID string    `json:"request_id,omitempty"`
 }
 
 type Credentials struct {
@@ -35,23 +228,206 @@ type Credentials struct {
 }
 
 func main() {
-	// Load .env file
-	err := godotenv.Load(".env")
+	// Load .env files. They are optional since production deployments set
+	// the environment directly. Logging is configured from the result, so
+	// outcomes are logged once the logger exists.
+	missingEnvFiles, envErr := loadEnvFiles()
+
+	var err error
+	logger, err = newLogger(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		logger = slog.Default()
+		fatal("Invalid logging configuration", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	if envErr != nil {
+		fatal("Error loading env file", "error", envErr)
+	}
+	for _, path := range missingEnvFiles {
+		logger.Debug("Env file not found, skipping", "path", path)
+	}
+
+	if err := loadConfigFile(); err != nil {
+		fatal("Failed to load config file", "error", err)
+	}
+	if err := validateConfig(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	loadConfig()
+
+	// "fileupload upload file..." pins files directly instead of serving.
+	if len(os.Args) > 1 && os.Args[1] == "upload" {
+		os.Exit(runUploadCommand(os.Args[2:]))
+	}
+
+	logConfig()
+
+	if uploadTempDir != "" {
+		if err := prepareUploadTempDir(uploadTempDir); err != nil {
+			fatal("Upload temp directory is unusable", "error", err)
+		}
+	}
+
+	if dbPath := os.Getenv("DB_PATH"); dbPath != "" {
+		store, err = openUploadStore(dbPath)
+		if err != nil {
+			fatal("Failed to open upload store", "path", dbPath, "error", err)
+		}
+		defer store.Close()
+		logger.Info("Upload persistence enabled", "path", dbPath)
+	}
+
+	if statsdAddr != "" {
+		statsd, err = newStatsdClient(statsdAddr, statsdPrefix, statsdTags)
+		if err != nil {
+			fatal("Failed to set up statsd", "error", err)
+		}
+		defer statsd.Close()
+		logger.Info("Statsd metrics enabled", "addr", statsdAddr)
+	}
+
+	shutdownTracing, err := initTracing(context.Background())
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		fatal("Failed to set up tracing", "error", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warn("Failed to flush traces", "error", err)
+		}
+	}()
+
+	limiter := newIPRateLimiter(rateLimitRPS, rateLimitBurst)
+	idempotency = newIdempotencyStore(idempotencyTTL)
+	uploadSlots = newUploadSlots(uploadQueueDepth)
+	if dedupCacheEnabled {
+		dedupCache = newContentCache(dedupCacheTTL)
+	}
+
+	// API routes are rate limited per client IP; probes and metrics are not.
+	api := func(h http.HandlerFunc) http.Handler {
+		return corsMiddleware(limiter.middleware(h))
+	}
+	// Protected routes additionally require authentication when configured,
+	// and may carry the caller's own Pinata credentials.
+	protected := func(h http.HandlerFunc) http.Handler {
+		return api(authMiddleware(pinataCredentialsMiddleware(h)).ServeHTTP)
+	}
+
+	// Routes that pin content also count towards the caller's quota.
+	pinning := func(h http.HandlerFunc) http.Handler {
+		return protected(enforceQuota(h))
 	}
 
 	// http.HandleFunc("/upload", handleUpload)
-	http.Handle("/upload", corsMiddleware(http.HandlerFunc(handleUpload)))
-	fmt.Println("Server is running on http://localhost:9000")
-	log.Fatal(http.ListenAndServe(":9000", nil))
+	http.Handle("/login", api(handleLogin))
+	http.Handle("/upload", pinning(limitUploadQueue(handleUpload)))
+	http.Handle("/upload-base64", pinning(handleUploadBase64))
+	http.Handle("/upload-raw", pinning(handleUploadRaw))
+	http.Handle("/upload-url", pinning(handleUploadURL))
+	http.Handle("/unpin", protected(handleUnpin))
+	http.Handle("/unpin/", protected(handleUnpin))
+	http.Handle("/unpin-batch", protected(handleUnpinBatch))
+	http.Handle("/pins", protected(handleListPins))
+	http.Handle("/pins/metadata", protected(handlePinMetadata))
+	http.Handle("/status", protected(handlePinStatus))
+	http.Handle("/pin-by-hash", pinning(handlePinByHash))
+	http.Handle("/pin-json", pinning(handlePinJSON))
+	http.Handle("/quota", api(authMiddleware(http.HandlerFunc(handleQuota)).ServeHTTP))
+	http.Handle("/ipfs/", api(handleGatewayProxy))
+	http.Handle("/decrypt/", protected(handleDecrypt))
+	// Admin routes only read local state, so they skip the Pinata
+	// credentials check but require the admin role.
+	http.Handle("/admin/uploads", api(authMiddleware(requireAdmin(http.HandlerFunc(handleAdminUploads))).ServeHTTP))
+	http.HandleFunc("/health", handleHealth)
+	http.HandleFunc("/ready", handleReady)
+	http.HandleFunc("/version", handleVersion)
+	http.HandleFunc("/stats", handleStats)
+	http.Handle("/metrics", promhttp.Handler())
+
+	// Panics are recovered ahead of every route's own middleware, inside
+	// the request ID so the logged trace can be correlated. Security headers
+	// are set outside the recovery so the 500 it writes carries them too.
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           requestIDMiddleware(securityHeadersMiddleware(recoverMiddleware(tracingMiddleware(http.DefaultServeMux)))),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The re-pin job needs the upload history and only knows how to check
+	// Pinata.
+	if repinCheckInterval > 0 {
+		_, pinata := storage.(PinataProvider)
+		switch {
+		case store == nil:
+			logger.Warn("REPIN_CHECK_INTERVAL is set but persistence is disabled, re-pin checks are off")
+		case !pinata:
+			logger.Warn("REPIN_CHECK_INTERVAL is set but the storage provider is not pinata, re-pin checks are off")
+		default:
+			go runRepinChecks(ctx, repinCheckInterval)
+		}
+	}
+
+	// Expiries can only be recorded with persistence and Pinata, so the
+	// scheduler runs whenever both are available.
+	if _, pinata := storage.(PinataProvider); pinata && store != nil {
+		go runExpiryChecks(ctx, expiryCheckInterval)
+	}
+
+	useTLS := tlsCertFile != ""
+
+	listener, err := listen(listenAddr)
+	if err != nil {
+		fatal("Failed to listen", "addr", listenAddr, "error", err)
+	}
+
+	go func() {
+		var err error
+		if useTLS {
+			logger.Info("Server is running", "addr", listenAddr, "mode", "https")
+			err = server.ServeTLS(listener, tlsCertFile, tlsKeyFile)
+		} else {
+			logger.Info("Server is running", "addr", listenAddr, "mode", "http")
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Server failed", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	logger.Info("Shutting down, draining in-flight requests", "timeout", shutdownTimeout)
+
+	// In-flight uploads keep their own request contexts, so they are allowed
+	// to finish within the drain window.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Shutdown timed out", "error", err)
+		return
+	}
+	logger.Info("Shutdown complete")
 }
 
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, pinata_api_key, pinata_secret_api_key")
+		w.Header().Add("Vary", "Origin")
+		if origin := allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, Authorization, Range, Idempotency-Key, X-API-Key, X-Request-ID, X-Filename, traceparent, tracestate, pinata_api_key, pinata_secret_api_key, pinata_jwt, X-Pinata-Metadata")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Content-Length, Content-Range, Accept-Ranges")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusNoContent)
@@ -62,127 +438,814 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request
+// from origin, or "" when the origin is not allowed.
+func allowedOrigin(origin string) string {
+	for _, allowed := range corsAllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
 func handleUpload(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleUpload")
+	defer span.End()
+	r = r.WithContext(ctx)
+
 	if r.Method != http.MethodPost {
 		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	dry := isDryRun(r)
+	if !dry && rejectIfCircuitOpen(w) {
+		return
+	}
+
+	// The deadline covers reading the body as well as the uploads, so a
+	// slow client cannot hold the request open past it either.
+	if requestUploadDeadline > 0 {
+		ctx, cancel := context.WithTimeoutCause(r.Context(), requestUploadDeadline, errUploadDeadline)
+		defer cancel()
+		r = r.WithContext(ctx)
+		http.NewResponseController(w).SetReadDeadline(time.Now().Add(requestUploadDeadline))
+	}
+
+	// ParseMultipartForm only bounds what is held in memory and spools the
+	// rest to disk, so the whole body is capped first. Compressed bodies are
+	// capped again after decompression, which is the limit that matters.
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	gzipped, err := gzipRequestBody(w, r, maxRequestBytes)
+	if err != nil {
+		sendErrorResponse(w, "Invalid gzip request body", http.StatusBadRequest)
+		return
+	}
 
-	err := r.ParseMultipartForm(maxFileSize)
+	err = r.ParseMultipartForm(maxFileSize)
 	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendErrorResponse(w, fmt.Sprintf("Request body exceeds limit of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if isDiskFull(err) {
+			spillDiskFull.Store(true)
+			loggerFrom(r.Context()).Error("Upload temp directory is full", "dir", os.TempDir(), "error", err)
+			sendErrorResponse(w, "Insufficient storage to buffer the upload, try again later", http.StatusInsufficientStorage)
+			return
+		}
+		if gzipped && isGzipError(err) {
+			sendErrorResponse(w, "Invalid gzip request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			loggerFrom(r.Context()).Warn("Request hit the upload deadline while reading the body", "deadline", requestUploadDeadline)
+			sendCodedError(w, CodeDeadlineExceeded, fmt.Sprintf("Request exceeded the upload deadline of %s", requestUploadDeadline), http.StatusRequestTimeout)
+			return
+		}
 		sendErrorResponse(w, "Failed to parse multipart form: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	// Spooled parts are removed even when the upload fails partway.
+	defer r.MultipartForm.RemoveAll()
 
-	files := r.MultipartForm.File["files"]
+	// A retried request carrying the same Idempotency-Key gets the original
+	// response instead of pinning the files again. Dry runs are not
+	// recorded, so a later real request with the key still uploads.
+	if key := r.Header.Get(idempotencyKeyHeader); key != "" && idempotency != nil && !dry {
+		rec, ok := idempotency.begin(w, r, key)
+		if !ok {
+			return
+		}
+		defer rec.finish()
+		w = rec
+	}
+
+	// Frontends differ in the field they post files under, so every
+	// configured name is accepted and merged in order.
+	var files []*multipart.FileHeader
+	for _, field := range uploadFieldNames {
+		files = append(files, r.MultipartForm.File[field]...)
+	}
 	if len(files) == 0 {
 		sendErrorResponse(w, "No files were uploaded", http.StatusBadRequest)
 		return
 	}
+	if len(files) > maxFilesPerUpload {
+		sendCodedError(w, CodeTooManyFiles, fmt.Sprintf("Too many files: received %d, the limit is %d per request", len(files), maxFilesPerUpload), http.StatusBadRequest)
+		return
+	}
+
+	var opts uploadOptions
+	if raw := r.FormValue("pinataMetadata"); raw != "" {
+		var metadata PinataMetadata
+		if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+			sendErrorResponse(w, "Invalid pinataMetadata JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Metadata = &metadata
+	}
+
+	// A name query parameter is a shortcut for pinataMetadata's name, which
+	// takes precedence when both are given.
+	pinName := strings.TrimSpace(r.URL.Query().Get("name"))
+	if opts.Metadata != nil && opts.Metadata.Name != "" {
+		pinName = ""
+	}
+
+	if raw := r.FormValue("cid_version"); raw != "" {
+		if raw != "0" && raw != "1" {
+			sendErrorResponse(w, "Invalid cid_version: must be 0 or 1", http.StatusBadRequest)
+			return
+		}
+		cidVersion := int(raw[0] - '0')
+		opts.Options = &PinataOptions{CIDVersion: &cidVersion}
+	}
+
+	if groupID := strings.TrimSpace(r.FormValue("group_id")); groupID != "" {
+		opts = opts.withGroup(groupID)
+	} else if pinataGroupID != "" {
+		opts = opts.withGroup(pinataGroupID)
+	}
+
+	// Expiring pins are tracked in the upload store and removed with the
+	// server's own Pinata credentials. Content the server already keeps
+	// pinned without a ttl is not expired by a later upload with one.
+	if raw := r.FormValue("ttl"); raw != "" {
+		ttl, err := parseTTL(raw)
+		if err != nil {
+			sendErrorResponse(w, "Invalid ttl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := storage.(PinataProvider); !ok || store == nil {
+			sendErrorResponse(w, "ttl requires the pinata storage provider and persistence (DB_PATH)", http.StatusBadRequest)
+			return
+		}
+		if _, ok := requestPinataCredentials(r.Context()); ok {
+			sendErrorResponse(w, "ttl is not supported with per-request Pinata credentials", http.StatusBadRequest)
+			return
+		}
+		opts.ExpiresAt = time.Now().Add(ttl).UTC().Truncate(time.Second)
+	}
+
+	// Clients uploading a folder send one "paths" value per file holding its
+	// relative path, since multipart filenames are reduced to base names.
+	if paths := r.MultipartForm.Value["paths"]; len(paths) > 0 {
+		if len(paths) != len(files) {
+			sendErrorResponse(w, fmt.Sprintf("Expected one paths entry per file, got %d paths for %d files", len(paths), len(files)), http.StatusBadRequest)
+			return
+		}
+		if isDirectoryUpload(paths) {
+			if dry {
+				sendErrorResponse(w, "Directory uploads do not support dry runs", http.StatusBadRequest)
+				return
+			}
+			// Directory uploads are built as a single Pinata request.
+			if _, ok := storage.(PinataProvider); !ok {
+				sendErrorResponse(w, "Directory uploads are not supported by the configured storage provider", http.StatusBadRequest)
+				return
+			}
+			// Directory entries are streamed as-is, which would bypass
+			// encryption.
+			if encryptionAEAD != nil {
+				sendErrorResponse(w, "Directory uploads are not supported when encryption is enabled", http.StatusBadRequest)
+				return
+			}
+			if pinName != "" {
+				opts = opts.withName(pinName)
+			}
+			handleDirectoryUpload(w, r, files, paths, opts)
+			return
+		}
+	}
 
-	responses := make([]PinataResponse, 0, len(files))
-	errors := make([]string, 0)
+	batch := newUploadBatch(len(files))
+	if wantsNDJSON(r) {
+		batch.stream = startNDJSON(w)
+	}
 
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
-	for _, fileHeader := range files {
+	// Files are keyed by content hash so that duplicates within the batch
+	// reuse the first upload instead of being pinned again.
+	uploaded := make(map[string]UploadResponse)
+	seen := make(map[string]bool)
+	var duplicates []uploadJob
+
+	// A fixed pool of workers drains the jobs channel so that at most
+	// uploadConcurrency files are sent to Pinata at once. Each worker
+	// expanding an archive holds up to maxArchiveExpandedSize in memory.
+	jobs := make(chan uploadJob)
+	for i := 0; i < min(uploadConcurrency, len(files)); i++ {
 		wg.Add(1)
-		go func(fh *multipart.FileHeader) {
+		go func() {
 			defer wg.Done()
 
-			response, err := uploadFileToPinata(fh)
-			mu.Lock()
-			defer mu.Unlock()
+			for job := range jobs {
+				fh := job.fh
+				if r.Context().Err() != nil {
+					batch.failCanceled(r.Context(), job.index, fh.Filename)
+					continue
+				}
+
+				fileOpts := opts
+				if pinName != "" {
+					fileOpts = opts.withName(batchPinName(pinName, job.index, len(files)))
+				}
+
+				// Scanning reads the whole file, so it runs here with the
+				// worker's concurrency rather than before queuing.
+				if err := scanFile(r.Context(), fh); err != nil {
+					if r.Context().Err() != nil {
+						batch.failCanceled(r.Context(), job.index, fh.Filename)
+						continue
+					}
+					batch.fail(job.index, fh.Filename, errorCode(err, CodeScanFailed), err.Error())
+					continue
+				}
+
+				if expandArchives && isArchive(fh.Filename) {
+					upload, err := pinArchive(r.Context(), fh, job.sha256, fileOpts, dry)
+					if err != nil && r.Context().Err() != nil {
+						batch.failCanceled(r.Context(), job.index, fh.Filename)
+						continue
+					}
+					if err != nil {
+						loggerFrom(r.Context()).Warn("Archive upload failed", "filename", fh.Filename, "size", fh.Size, "error", err)
+						batch.fail(job.index, fh.Filename, errorCode(err, CodeUploadFailed), err.Error())
+						continue
+					}
+					mu.Lock()
+					uploaded[job.sha256] = upload
+					mu.Unlock()
+					batch.succeed(job.index, fh.Filename, fh.Size, upload)
+					continue
+				}
+
+				start := time.Now()
+				var response UploadResult
+				var err error
+				if dry {
+					response, err = simulateUpload(fh, fileOpts)
+				} else {
+					response, _, err = uploadDeduped(r.Context(), job.sha256, fileOpts, func() (UploadResult, error) {
+						return uploadFile(r.Context(), fh, fileOpts)
+					})
+				}
+				if err != nil && r.Context().Err() != nil {
+					loggerFrom(r.Context()).Info("Upload canceled", "filename", fh.Filename, "size", fh.Size, "duration", time.Since(start))
+					batch.failCanceled(r.Context(), job.index, fh.Filename)
+					continue
+				}
+				if err != nil {
+					loggerFrom(r.Context()).Warn("Upload failed", "filename", fh.Filename, "size", fh.Size, "duration", time.Since(start), "error", err)
+					batch.fail(job.index, fh.Filename, uploadErrorCode(err), fmt.Sprintf("Error uploading %s: %v", fh.Filename, err))
+					continue
+				}
+				if dry {
+					loggerFrom(r.Context()).Info("Dry run succeeded", "filename", fh.Filename, "size", fh.Size, "cid", response.CID)
+				} else {
+					loggerFrom(r.Context()).Info("Upload succeeded", "filename", fh.Filename, "size", fh.Size, "cid", response.CID, "duration", time.Since(start))
+				}
 
-			if err != nil {
-				errors = append(errors, fmt.Sprintf("Error uploading %s: %v", fh.Filename, err))
-			} else {
-				responses = append(responses, response)
+				var upload UploadResponse
+				if dry {
+					upload = newDryRunResponse(response, job.sha256)
+				} else {
+					upload = newUploadResponse(response, job.sha256)
+					addFileThumbnail(r.Context(), &upload, fh, fileOpts)
+				}
+				if !opts.ExpiresAt.IsZero() {
+					upload.ExpiresAt = &opts.ExpiresAt
+				}
+				mu.Lock()
+				uploaded[job.sha256] = upload
+				mu.Unlock()
+
+				batch.succeed(job.index, fh.Filename, fh.Size, upload)
+				if !dry {
+					recordExpiringUpload(r.Context(), fh.Filename, fh.Size, response.CID, opts.ExpiresAt)
+					if !response.Cached {
+						chargeQuota(r.Context(), fh.Size)
+					}
+				}
 			}
-		}(fileHeader)
+		}()
+	}
+
+	for i, fileHeader := range files {
+		// Once the client has gone away the remaining files are only
+		// reported, never uploaded.
+		if r.Context().Err() != nil {
+			batch.failCanceled(r.Context(), i, fileHeader.Filename)
+			continue
+		}
+
+		name, err := sanitizeFilename(fileHeader.Filename)
+		if err != nil {
+			batch.fail(i, fileHeader.Filename, errorCode(err, CodeInvalidFilename), err.Error())
+			continue
+		}
+		fileHeader.Filename = name
+
+		if err := validateFile(fileHeader); err != nil {
+			batch.fail(i, fileHeader.Filename, errorCode(err, CodeBadRequest), err.Error())
+			continue
+		}
+
+		sum, err := fileSHA256(fileHeader)
+		if err != nil {
+			batch.fail(i, fileHeader.Filename, CodeInternal, fmt.Sprintf("Error uploading %s: %v", fileHeader.Filename, err))
+			continue
+		}
+
+		job := uploadJob{index: i, fh: fileHeader, sha256: sum}
+		if seen[sum] {
+			duplicates = append(duplicates, job)
+			continue
+		}
+		seen[sum] = true
+
+		select {
+		case jobs <- job:
+		case <-r.Context().Done():
+			batch.failCanceled(r.Context(), i, fileHeader.Filename)
+		}
 	}
+	close(jobs)
 
 	wg.Wait()
 
-	result := struct {
-		SuccessfulUploads []PinataResponse `json:"successful_uploads"`
-		Errors            []string         `json:"errors,omitempty"`
-	}{
-		SuccessfulUploads: responses,
-		Errors:            errors,
+	switch {
+	case errors.Is(context.Cause(r.Context()), errUploadDeadline):
+		loggerFrom(r.Context()).Warn("Request hit the upload deadline, remaining uploads were canceled", "files", len(files), "deadline", requestUploadDeadline)
+	case r.Context().Err() != nil:
+		loggerFrom(r.Context()).Warn("Client disconnected, remaining uploads were canceled", "files", len(files))
+	}
+
+	for _, job := range duplicates {
+		upload, ok := uploaded[job.sha256]
+		if !ok && r.Context().Err() != nil {
+			batch.failCanceled(r.Context(), job.index, job.fh.Filename)
+			continue
+		}
+		if !ok {
+			batch.fail(job.index, job.fh.Filename, CodeUploadFailed, fmt.Sprintf("Error uploading %s: identical content failed to upload earlier in this request", job.fh.Filename))
+			continue
+		}
+		batch.succeed(job.index, job.fh.Filename, job.fh.Size, upload)
+		if !dry && upload.IpfsHash != "" {
+			recordExpiringUpload(r.Context(), job.fh.Filename, job.fh.Size, upload.IpfsHash, opts.ExpiresAt)
+		}
+	}
+
+	result := batch.result()
+	span.SetAttributes(
+		attribute.Int("upload.files", len(files)),
+		attribute.Int("upload.succeeded", len(result.SuccessfulUploads)),
+		attribute.Int("upload.failed", len(result.Errors)),
+		attribute.Bool("upload.dry_run", dry),
+	)
+	if !dry {
+		recordBatchOutcome(r.Context(), result)
+		notifyWebhook(r.Context(), WebhookPayload{
+			RequestID:         requestIDFrom(r.Context()),
+			SuccessfulUploads: result.SuccessfulUploads,
+			Errors:            errorMessages(result.Errors),
+			TotalSize:         batch.totalSize,
+		})
+	}
+
+	// Streamed responses have already written every result.
+	if batch.stream != nil {
+		return
 	}
+	writeBatchResponse(w, r, result)
+}
+
+// batchPinName returns the pin name for the file at index when a batch of
+// count files is uploaded under name. Files in a multi-file batch are told
+// apart by a 1-based suffix.
+func batchPinName(name string, index, count int) string {
+	if count == 1 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, index+1)
+}
+
+// errUploadDeadline is the cause of a request context canceled by
+// REQUEST_UPLOAD_DEADLINE.
+var errUploadDeadline = errors.New("request upload deadline exceeded")
+
+// disconnectedMessage is the per-file error for files that were not uploaded
+// because the client went away mid-request.
+func disconnectedMessage(filename string) string {
+	return fmt.Sprintf("Upload of %s canceled: client disconnected", filename)
+}
+
+// failCanceled records an error for a file that was not uploaded because
+// ctx, the request's context, ended: either the client went away or the
+// request reached its upload deadline.
+func (b *uploadBatch) failCanceled(ctx context.Context, index int, filename string) {
+	if errors.Is(context.Cause(ctx), errUploadDeadline) {
+		b.fail(index, filename, CodeDeadlineExceeded, fmt.Sprintf("Upload of %s canceled: request exceeded the upload deadline of %s", filename, requestUploadDeadline))
+		return
+	}
+	b.fail(index, filename, CodeClientDisconnected, disconnectedMessage(filename))
+}
+
+// writeBatchResponse fills in the totals and writes result with 207 when any
+// file failed.
+func writeBatchResponse(w http.ResponseWriter, r *http.Request, result BatchUploadResponse) {
+	result.TotalPinSize = 0
+	for _, upload := range result.SuccessfulUploads {
+		result.TotalPinSize += int64(upload.PinSize)
+	}
+	result.SuccessfulCount = len(result.SuccessfulUploads)
+	result.FailedCount = len(result.Errors)
 
 	w.Header().Set("Content-Type", "application/json")
-	if len(errors) > 0 {
-		w.WriteHeader(http.StatusPartialContent)
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
 	} else {
 		w.WriteHeader(http.StatusOK)
 	}
+	if r.URL.Query().Get("legacy_errors") == "true" {
+		json.NewEncoder(w).Encode(legacyBatchUploadResponse{BatchUploadResponse: result, Errors: errorMessages(result.Errors)})
+		return
+	}
 	json.NewEncoder(w).Encode(result)
 }
 
-func uploadFileToPinata(fileHeader *multipart.FileHeader) (PinataResponse, error) {
-	file, err := fileHeader.Open()
+// errorMessages returns the message of each error.
+func errorMessages(errs []FileError) []string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+	}
+	return messages
+}
+
+// handleHealth is a liveness probe. It never contacts Pinata.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleUnpin removes a pin from Pinata. The CID is taken from the path
+// (/unpin/{cid}) or the cid query parameter.
+func handleUnpin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cid := strings.Trim(strings.TrimPrefix(r.URL.Path, "/unpin"), "/")
+	if cid == "" {
+		cid = r.URL.Query().Get("cid")
+	}
+	if cid == "" {
+		sendErrorResponse(w, "Missing CID", http.StatusBadRequest)
+		return
+	}
+
+	err := unpinCID(r.Context(), cid)
+	if errors.Is(err, errNotPinned) {
+		sendErrorResponse(w, fmt.Sprintf("CID %s is not pinned", cid), http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		return PinataResponse{}, fmt.Errorf("failed to open file: %w", err)
+		sendErrorResponse(w, fmt.Sprintf("Error unpinning %s: %v", cid, err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UnpinResponse{CID: cid, Status: "unpinned"})
+}
+
+// unpinCID unpins cid and records that it was deliberately removed.
+// errNotPinned is returned when there was nothing to unpin.
+func unpinCID(ctx context.Context, cid string) error {
+	err := unpinFromPinata(ctx, cid)
+	if errors.Is(err, errNotPinned) {
+		return err
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("Unpin failed", "cid", cid, "error", err)
+		return err
+	}
+
+	recordUnpin(ctx, cid)
+	return nil
+}
+
+// handleListPins returns a page of the pins held by the configured Pinata
+// account.
+func handleListPins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !hasPinataCredentials() {
+		sendErrorResponse(w, "Pinata credentials are not configured", http.StatusInternalServerError)
+		return
+	}
+
+	limit, err := queryInt(r, "pageLimit", defaultPinListLimit)
+	if err != nil || limit < 1 || limit > maxPinListLimit {
+		sendErrorResponse(w, fmt.Sprintf("Invalid pageLimit: must be between 1 and %d", maxPinListLimit), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := queryInt(r, "pageOffset", 0)
+	if err != nil || offset < 0 {
+		sendErrorResponse(w, "Invalid pageOffset: must be a non-negative integer", http.StatusBadRequest)
+		return
 	}
-	defer file.Close()
 
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
+	params := url.Values{}
+	params.Set("status", "pinned")
+	params.Set("pageLimit", strconv.Itoa(limit))
+	params.Set("pageOffset", strconv.Itoa(offset))
 
-	part, err := writer.CreateFormFile("file", filepath.Base(fileHeader.Filename))
+	pins, err := listPinataPins(r.Context(), params)
 	if err != nil {
-		return PinataResponse{}, fmt.Errorf("failed to create form file: %w", err)
+		loggerFrom(r.Context()).Error("Listing pins failed", "error", err)
+		sendErrorResponse(w, "Error listing pins: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pins)
+}
+
+// handlePinByHash pins an existing CID without uploading its content.
+func handlePinByHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request PinByHashRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		sendErrorResponse(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request.HashToPin = strings.TrimSpace(request.HashToPin)
+	if !isPlausibleCID(request.HashToPin) {
+		sendErrorResponse(w, fmt.Sprintf("Invalid hashToPin: expected an alphanumeric CID of %d to %d characters", minCIDLength, maxCIDLength), http.StatusBadRequest)
+		return
+	}
+
+	pin, err := pinByHash(r.Context(), request)
+	if err != nil {
+		loggerFrom(r.Context()).Error("Pin by hash failed", "cid", request.HashToPin, "error", err)
+		sendErrorResponse(w, fmt.Sprintf("Error pinning %s: %v", request.HashToPin, err), http.StatusBadGateway)
+		return
 	}
+	// Pinning by hash fetches content from the network asynchronously, so
+	// only the pin counts towards the quota.
+	chargeQuota(r.Context(), 0)
 
-	_, err = io.Copy(part, file)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pin)
+}
+
+// pinBytesAndRespond validates and pins a single in-memory file, writing the
+// resulting UploadResponse or an error to w.
+func pinBytesAndRespond(w http.ResponseWriter, r *http.Request, filename string, data []byte) {
+	filename, err := sanitizeFilename(filename)
 	if err != nil {
-		return PinataResponse{}, fmt.Errorf("failed to copy file content: %w", err)
+		sendCodedError(w, errorCode(err, CodeInvalidFilename), err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateExtension(filename); err != nil {
+		sendCodedError(w, errorCode(err, CodeBlockedExtension), err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > maxPerFileSize {
+		sendCodedError(w, CodeFileTooLarge, fmt.Sprintf("file %s is %d bytes and exceeds per-file limit of %d bytes", filename, len(data), maxPerFileSize), http.StatusRequestEntityTooLarge)
+		return
 	}
 
-	err = writer.Close()
+	err = validateUpload(filename, int64(len(data)), func() (string, error) {
+		return http.DetectContentType(data[:min(len(data), sniffLen)]), nil
+	})
+	if err == nil {
+		err = validateImageDimensions(filename, bytes.NewReader(data))
+	}
 	if err != nil {
-		return PinataResponse{}, fmt.Errorf("failed to close multipart writer: %w", err)
+		sendCodedError(w, errorCode(err, CodeBadRequest), err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Load environment variables
-	pinataAPIKey := os.Getenv("PINATA_API_KEY")
-	pinataAPISecret := os.Getenv("PINATA_API_SECRET")
-	pinataAPIURL := os.Getenv("PINATA_API_URL")
+	if err := scanContent(r.Context(), filename, bytes.NewReader(data)); err != nil {
+		status := http.StatusBadGateway
+		var infected *infectedError
+		if errors.As(err, &infected) {
+			status = http.StatusUnprocessableEntity
+		}
+		sendCodedError(w, errorCode(err, CodeScanFailed), err.Error(), status)
+		return
+	}
+
+	var opts uploadOptions
+	if pinataGroupID != "" {
+		opts = opts.withGroup(pinataGroupID)
+	}
 
-	req, err := http.NewRequest("POST", pinataAPIURL, &requestBody)
+	sum := bytesSHA256(data)
+	response, cached, err := uploadDeduped(r.Context(), sum, opts, func() (UploadResult, error) {
+		return uploadStripped(r.Context(), filename, bytes.NewReader(data), opts)
+	})
 	if err != nil {
-		return PinataResponse{}, fmt.Errorf("failed to create request: %w", err)
+		countUpload("error")
+		loggerFrom(r.Context()).Warn("Upload failed", "filename", filename, "size", len(data), "error", err)
+		if errors.Is(err, errCircuitOpen) {
+			_, retryIn := pinataBreaker.status()
+			sendCircuitOpen(w, retryIn)
+			return
+		}
+		sendCodedError(w, uploadErrorCode(err), fmt.Sprintf("Error uploading %s: %v", filename, err), http.StatusBadGateway)
+		return
 	}
+	countUpload("success")
+	loggerFrom(r.Context()).Info("Upload succeeded", "filename", filename, "size", len(data), "cid", response.CID)
+	recordUpload(r.Context(), filename, int64(len(data)), response.CID)
+	if !cached {
+		chargeQuota(r.Context(), int64(len(data)))
+	}
+
+	upload := newUploadResponse(response, sum)
+	addThumbnail(r.Context(), &upload, filename, bytes.NewReader(data), opts)
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("pinata_api_key", pinataAPIKey)
-	req.Header.Set("pinata_secret_api_key", pinataAPISecret)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(upload)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// uploadFile stores an uploaded multipart file with the configured storage
+// provider.
+func uploadFile(ctx context.Context, fileHeader *multipart.FileHeader, opts uploadOptions) (UploadResult, error) {
+	file, err := fileHeader.Open()
 	if err != nil {
-		return PinataResponse{}, fmt.Errorf("failed to send request: %w", err)
+		return UploadResult{}, fmt.Errorf("failed to open file: %w", err)
 	}
-	defer resp.Body.Close()
+	defer file.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return PinataResponse{}, fmt.Errorf("pinata API returned non-OK status: %s", resp.Status)
+	opts.ContentType = fileHeader.Header.Get("Content-Type")
+	return uploadStripped(ctx, fileHeader.Filename, file, opts)
+}
+
+// uploadContent stores the content read from r under filename with the
+// configured storage provider, recording upload metrics. When encryption is
+// enabled the provider only ever sees the ciphertext.
+func uploadContent(ctx context.Context, filename string, r io.Reader, opts uploadOptions) (UploadResult, error) {
+	ctx, span := tracer.Start(ctx, "uploadFile", trace.WithAttributes(
+		attribute.String("upload.filename", filename),
+		attribute.String("upload.provider", storageProvider),
+	))
+	defer span.End()
+
+	defer uploadStarted()()
+
+	hashed := newHashingReader(r)
+	r = hashed.reader()
+
+	timer := startUploadTimer()
+	if encryptionAEAD != nil {
+		sealed, err := encryptContent(r)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		r = bytes.NewReader(sealed)
+		// The provider stores ciphertext, whatever the file was.
+		opts.ContentType = ""
+	}
+
+	var result UploadResult
+	var err error
+	switch {
+	case secondaryStorage != nil || fallbackStorage != nil:
+		// Content is buffered once so that it can be sent to more than one
+		// provider.
+		var data []byte
+		data, err = io.ReadAll(r)
+		if err != nil {
+			break
+		}
+		if secondaryStorage != nil {
+			result, err = uploadRedundant(withUploadOptions(ctx, opts), filename, data)
+		} else {
+			result, err = uploadWithFallback(withUploadOptions(ctx, opts), filename, data)
+		}
+	default:
+		result, err = storage.Upload(withUploadOptions(ctx, opts), filename, r)
+		result.Provider = storageProvider
 	}
+	result.Duration = timer.ObserveDuration()
+	result.Encrypted = encryptionAEAD != nil
 
-	var pinataResp PinataResponse
-	err = json.NewDecoder(resp.Body).Decode(&pinataResp)
 	if err != nil {
-		return PinataResponse{}, fmt.Errorf("failed to decode Pinata response: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		var statusErr *pinataStatusError
+		if errors.As(err, &statusErr) {
+			span.SetAttributes(attribute.Int("pinata.status_code", statusErr.StatusCode))
+		}
+	} else {
+		result.ContentSHA256 = hashed.Sum()
+		activity.bytesPinned.Add(int64(result.Size))
+		span.SetAttributes(attribute.String("upload.cid", result.CID), attribute.Int("upload.size", result.Size), attribute.String("upload.stored_by", result.Provider))
+		if result.SecondaryCID != "" {
+			span.SetAttributes(attribute.String("upload.secondary_cid", result.SecondaryCID))
+		}
+	}
+	return result, err
+}
+
+// newUploadResponse builds the client-facing entry for a stored file.
+func newUploadResponse(result UploadResult, sha256 string) UploadResponse {
+	response := UploadResponse{
+		PinataResponse: PinataResponse{
+			IpfsHash:  result.CID,
+			PinSize:   result.Size,
+			Timestamp: result.Timestamp,
+		},
+		Provider:         result.Provider,
+		GatewayURL:       gatewayURL(result.CID),
+		SHA256:           sha256,
+		DurationMS:       result.Duration.Milliseconds(),
+		Encrypted:        result.Encrypted,
+		CIDv1:            cidV1(result.CID),
+		GroupID:          result.GroupID,
+		MetadataStripped: result.MetadataStripped,
+		ContentSHA256:    result.ContentSHA256,
+		Cached:           result.Cached,
+	}
+	if secondaryStorage != nil {
+		response.SecondaryProvider = secondaryStorageProvider
+		response.SecondaryCID = result.SecondaryCID
+		if result.SecondaryErr != nil {
+			response.SecondaryError = result.SecondaryErr.Error()
+		} else {
+			response.CIDMismatch = cidsDiffer(result.CID, result.SecondaryCID)
+		}
 	}
+	return response
+}
 
-	return pinataResp, nil
+// gatewayURL builds the public gateway URL for cid, avoiding duplicate
+// slashes between the gateway base and the CID.
+func gatewayURL(cid string) string {
+	return strings.TrimRight(ipfsGateway, "/") + "/" + strings.TrimLeft(cid, "/")
 }
 
+// isPlausibleCID performs a minimal sanity check on a CID string.
+func isPlausibleCID(cid string) bool {
+	if len(cid) < minCIDLength || len(cid) > maxCIDLength {
+		return false
+	}
+	for _, c := range cid {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// queryInt reads an integer query parameter, returning def when it is absent.
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// sendErrorResponse writes an ErrorResponse whose code is derived from the
+// HTTP status.
 func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
+	sendCodedError(w, statusErrorCode(statusCode), message, statusCode)
+}
+
+// sendCodedError is sendErrorResponse with a code more specific than the
+// one implied by the status.
+func sendCodedError(w http.ResponseWriter, code ErrorCode, message string, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code, RequestID: w.Header().Get("X-Request-ID")})
 }
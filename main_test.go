@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useMockStorage routes uploads to an in-memory MockProvider for the rest of
+// the test.
+func useMockStorage(t *testing.T) {
+	t.Helper()
+	prevStorage, prevProvider := storage, storageProvider
+	storage, storageProvider = MockProvider{}, "mock"
+	t.Cleanup(func() { storage, storageProvider = prevStorage, prevProvider })
+}
+
+// testFile is one file in a multipart upload built by newUploadRequest.
+type testFile struct {
+	name    string
+	content string
+}
+
+// newUploadRequest builds a POST /upload request carrying files.
+func newUploadRequest(t *testing.T, files ...testFile) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, f := range files {
+		part, err := writer.CreateFormFile("files", f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(f.content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestUploadRejectsEmptyFiles(t *testing.T) {
+	useMockStorage(t)
+
+	rec := httptest.NewRecorder()
+	handleUpload(rec, newUploadRequest(t,
+		testFile{"hello.txt", "hello"},
+		testFile{"empty.txt", ""},
+		testFile{"world.txt", "world"},
+	))
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusMultiStatus, rec.Body)
+	}
+	var result BatchUploadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessfulCount != 2 {
+		t.Errorf("successful_count = %d, want 2", result.SuccessfulCount)
+	}
+	if len(result.Errors) != 1 {
+		t.Fatalf("errors = %+v, want one for empty.txt", result.Errors)
+	}
+	if got := result.Errors[0]; got.Filename != "empty.txt" || got.Code != CodeEmptyFile || got.Message != "file empty.txt is empty" {
+		t.Errorf("error = %+v, want EMPTY_FILE for empty.txt", got)
+	}
+}
+
+func TestUploadLegacyErrors(t *testing.T) {
+	useMockStorage(t)
+
+	req := newUploadRequest(t, testFile{"hello.txt", "hello"}, testFile{"empty.txt", ""})
+	req.URL.RawQuery = "legacy_errors=true"
+	rec := httptest.NewRecorder()
+	handleUpload(rec, req)
+
+	var result struct {
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("decoding errors as plain messages: %v", err)
+	}
+	if len(result.Errors) != 1 || result.Errors[0] != "file empty.txt is empty" {
+		t.Errorf("errors = %q, want the message for empty.txt", result.Errors)
+	}
+}
+
+// stallingProvider is a StorageProvider that never finishes uploading files
+// named stall, giving up only when the request's context is done.
+type stallingProvider struct {
+	StorageProvider
+	stall string
+}
+
+func (p stallingProvider) Upload(ctx context.Context, filename string, file io.Reader) (UploadResult, error) {
+	if filename == p.stall {
+		<-ctx.Done()
+		return UploadResult{}, ctx.Err()
+	}
+	return p.StorageProvider.Upload(ctx, filename, file)
+}
+
+func TestUploadDeadlineReportsUnfinishedFiles(t *testing.T) {
+	prevStorage, prevProvider, prevDeadline := storage, storageProvider, requestUploadDeadline
+	storage, storageProvider, requestUploadDeadline = stallingProvider{MockProvider{}, "slow.txt"}, "mock", 50*time.Millisecond
+	t.Cleanup(func() { storage, storageProvider, requestUploadDeadline = prevStorage, prevProvider, prevDeadline })
+
+	rec := httptest.NewRecorder()
+	handleUpload(rec, newUploadRequest(t, testFile{"fast.txt", "fast"}, testFile{"slow.txt", "slow"}))
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusMultiStatus, rec.Body)
+	}
+	var result BatchUploadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessfulCount != 1 {
+		t.Errorf("successful_count = %d, want fast.txt uploaded", result.SuccessfulCount)
+	}
+	if len(result.Errors) != 1 || result.Errors[0].Filename != "slow.txt" || result.Errors[0].Code != CodeDeadlineExceeded {
+		t.Errorf("errors = %+v, want DEADLINE_EXCEEDED for slow.txt", result.Errors)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	uploadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileupload_uploads_total",
+		Help: "Files processed by /upload, by outcome.",
+	}, []string{"outcome"})
+
+	uploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "fileupload_pinata_upload_duration_seconds",
+		Help:    "Time taken to upload a single file to Pinata, including retries.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	})
+
+	uploadsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fileupload_uploads_in_flight",
+		Help: "Uploads to Pinata currently in progress.",
+	})
+
+	pinataCircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fileupload_pinata_circuit_state",
+		Help: "State of the Pinata circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+
+	repinsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileupload_repins_total",
+		Help: "CIDs found missing from Pinata and re-pinned, by outcome.",
+	}, []string{"outcome"})
+
+	expiredUnpinsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileupload_expired_unpins_total",
+		Help: "CIDs unpinned after their ttl passed, by outcome.",
+	}, []string{"outcome"})
+
+	uploadQueueGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "fileupload_upload_queue_depth",
+		Help: "/upload requests currently admitted by the upload queue.",
+	})
+
+	uploadQueueRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "fileupload_upload_queue_rejections_total",
+		Help: "/upload requests rejected with 503 because the upload queue was full.",
+	})
+
+	fallbacksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fileupload_provider_fallbacks_total",
+		Help: "Uploads sent to the fallback provider after the primary failed, by outcome.",
+	}, []string{"outcome"})
+)
+
+// inFlight tracks uploadsInFlight for statsd, which takes absolute gauge
+// values.
+var inFlight atomic.Int64
+
+// countUpload records the outcome of one uploaded file.
+func countUpload(outcome string) {
+	uploadsTotal.WithLabelValues(outcome).Inc()
+	statsd.count("uploads", 1, "outcome:"+outcome)
+	if outcome == "success" {
+		activity.uploads.Add(1)
+	} else {
+		activity.errors.Add(1)
+	}
+}
+
+// uploadStarted marks an upload to the provider as in progress. The caller
+// must call the returned function when it finishes.
+func uploadStarted() func() {
+	uploadsInFlight.Inc()
+	statsd.gauge("uploads_in_flight", inFlight.Add(1))
+	return func() {
+		uploadsInFlight.Dec()
+		statsd.gauge("uploads_in_flight", inFlight.Add(-1))
+	}
+}
+
+// uploadTimer measures a single upload to the provider.
+type uploadTimer struct {
+	start time.Time
+}
+
+// startUploadTimer starts timing an upload.
+func startUploadTimer() uploadTimer {
+	return uploadTimer{start: time.Now()}
+}
+
+// ObserveDuration records the time since the timer started and returns it.
+func (t uploadTimer) ObserveDuration() time.Duration {
+	d := time.Since(t.start)
+	uploadDuration.Observe(d.Seconds())
+	statsd.timing("pinata_upload_duration", d)
+	return d
+}
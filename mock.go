@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// cidEncoding is the lowercase, unpadded base32 alphabet used by CIDv1
+// strings with the "b" multibase prefix.
+var cidEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// MockProvider is an in-memory StorageProvider for local development and
+// tests. It never contacts a remote service: each upload is hashed and
+// answered with the CIDv1 of its content, so the same bytes always yield the
+// same CID.
+//
+// Set STORAGE_PROVIDER=mock to select it; no Pinata credentials are needed.
+// MOCK_LATENCY (e.g. "200ms") delays every attempt and MOCK_FAILURE_RATE
+// (0 to 1) makes that fraction of attempts fail with a retryable 503, which
+// exercises the same retry and error paths as a real provider. For example:
+//
+//	STORAGE_PROVIDER=mock MOCK_FAILURE_RATE=0.3 go run .
+//	curl -F files=@README.md http://localhost:9000/upload
+type MockProvider struct {
+	Latency     time.Duration
+	FailureRate float64
+}
+
+// Upload reads r to completion and returns its content-derived CID, after
+// simulating latency and failures for each attempt.
+func (m MockProvider) Upload(ctx context.Context, filename string, r io.Reader) (UploadResult, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return UploadResult{}, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := m.attempt(ctx)
+		if err == nil {
+			break
+		}
+		if attempt >= pinataMaxRetries || !isRetryable(err) || ctx.Err() != nil {
+			return UploadResult{}, err
+		}
+
+		delay := backoffDelay(attempt)
+		loggerFrom(ctx).Warn("Retrying upload", "filename", filepath.Base(filename), "attempt", attempt+1, "max_retries", pinataMaxRetries, "delay", delay, "error", err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return UploadResult{}, fmt.Errorf("upload canceled: %w", err)
+		}
+	}
+
+	return UploadResult{
+		CID:       mockCID(hash.Sum(nil)),
+		Size:      int(size),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// attempt simulates a single round trip to the storage service.
+func (m MockProvider) attempt(ctx context.Context) error {
+	if err := sleepContext(ctx, m.Latency); err != nil {
+		return fmt.Errorf("upload canceled: %w", err)
+	}
+	if m.FailureRate > 0 && rand.Float64() < m.FailureRate {
+		return &pinataStatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable (simulated)"}
+	}
+	return nil
+}
+
+// mockCID encodes a SHA-256 digest as a CIDv1 with the raw codec, the same
+// form IPFS produces for a single-block file.
+func mockCID(digest []byte) string {
+	// version 1, raw codec (0x55), sha2-256 multihash (0x12) of 32 bytes
+	prefix := []byte{0x01, 0x55, 0x12, 0x20}
+	return "b" + cidEncoding.EncodeToString(append(prefix, digest...))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// pinataMetadataHeader carries pinataMetadata for /pin-json when it is not
+// given in the query string.
+const pinataMetadataHeader = "X-Pinata-Metadata"
+
+// PinJSONRequest is the body sent to Pinata's pinJSONToIPFS endpoint.
+type PinJSONRequest struct {
+	PinataContent  json.RawMessage `json:"pinataContent"`
+	PinataMetadata *PinataMetadata `json:"pinataMetadata,omitempty"`
+	PinataOptions  *PinataOptions  `json:"pinataOptions,omitempty"`
+}
+
+// handlePinJSON pins the request body, which may be any JSON value, without
+// wrapping it in a multipart file. Optional pinataMetadata is read from the
+// query string or the X-Pinata-Metadata header.
+func handlePinJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPerFileSize)
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendErrorResponse(w, fmt.Sprintf("Request body exceeds limit of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		sendErrorResponse(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !json.Valid(content) {
+		sendErrorResponse(w, "Request body must be valid JSON", http.StatusBadRequest)
+		return
+	}
+
+	request := PinJSONRequest{PinataContent: content}
+
+	raw := r.URL.Query().Get("pinataMetadata")
+	if raw == "" {
+		raw = r.Header.Get(pinataMetadataHeader)
+	}
+	if raw != "" {
+		var metadata PinataMetadata
+		if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+			sendErrorResponse(w, "Invalid pinataMetadata JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.PinataMetadata = &metadata
+	}
+	if pinataGroupID != "" {
+		request.PinataOptions = &PinataOptions{GroupID: pinataGroupID}
+	}
+
+	response, err := pinJSON(r.Context(), request)
+	if err != nil {
+		countUpload("error")
+		loggerFrom(r.Context()).Warn("Pin JSON failed", "size", len(content), "error", err)
+		sendErrorResponse(w, fmt.Sprintf("Error pinning JSON: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	countUpload("success")
+	loggerFrom(r.Context()).Info("Pin JSON succeeded", "size", len(content), "cid", response.IpfsHash)
+	name := "json"
+	if request.PinataMetadata != nil && request.PinataMetadata.Name != "" {
+		name = request.PinataMetadata.Name
+	}
+	recordUpload(r.Context(), name, int64(len(content)), response.IpfsHash)
+	chargeQuota(r.Context(), int64(len(content)))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxMetadataUpdates bounds the number of CIDs updated by one request.
+const maxMetadataUpdates = 100
+
+// HashMetadataRequest is the body sent to Pinata's hashMetadata endpoint.
+type HashMetadataRequest struct {
+	IpfsPinHash string         `json:"ipfsPinHash"`
+	Name        string         `json:"name,omitempty"`
+	KeyValues   map[string]any `json:"keyvalues,omitempty"`
+}
+
+// PinMetadataUpdate is the new metadata for one pin.
+type PinMetadataUpdate struct {
+	CID       string         `json:"cid"`
+	Name      string         `json:"name,omitempty"`
+	KeyValues map[string]any `json:"keyvalues,omitempty"`
+}
+
+// PinMetadataRequest is the body accepted by /pins/metadata: either a
+// single update given inline, or a list of updates.
+type PinMetadataRequest struct {
+	PinMetadataUpdate
+	Updates []PinMetadataUpdate `json:"updates,omitempty"`
+}
+
+// PinMetadataResult is the outcome of updating one pin.
+type PinMetadataResult struct {
+	CID    string    `json:"cid"`
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Code   ErrorCode `json:"code,omitempty"`
+}
+
+// PinMetadataResponse is the body returned by /pins/metadata, with one
+// result per update in request order.
+type PinMetadataResponse struct {
+	Results         []PinMetadataResult `json:"results"`
+	SuccessfulCount int                 `json:"successful_count"`
+	FailedCount     int                 `json:"failed_count"`
+}
+
+// handlePinMetadata updates the name and keyvalues of pins that already
+// exist, responding 207 when only some of the updates succeed.
+func handlePinMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request PinMetadataRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		sendErrorResponse(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updates := request.Updates
+	if request.CID != "" {
+		updates = append([]PinMetadataUpdate{request.PinMetadataUpdate}, updates...)
+	}
+	if len(updates) == 0 {
+		sendErrorResponse(w, "No updates were given", http.StatusBadRequest)
+		return
+	}
+	if len(updates) > maxMetadataUpdates {
+		sendErrorResponse(w, fmt.Sprintf("Too many updates: received %d, the limit is %d per request", len(updates), maxMetadataUpdates), http.StatusBadRequest)
+		return
+	}
+
+	response := PinMetadataResponse{Results: make([]PinMetadataResult, len(updates))}
+
+	// Updates run with the same concurrency limit as uploads.
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, uploadConcurrency)
+	for i, update := range updates {
+		cid := strings.TrimSpace(update.CID)
+		if !isPlausibleCID(cid) {
+			response.Results[i] = PinMetadataResult{CID: update.CID, Error: fmt.Sprintf("Invalid cid: expected an alphanumeric CID of %d to %d characters", minCIDLength, maxCIDLength), Code: CodeBadRequest}
+			continue
+		}
+		if update.Name == "" && len(update.KeyValues) == 0 {
+			response.Results[i] = PinMetadataResult{CID: cid, Error: "Nothing to update: set name or keyvalues", Code: CodeBadRequest}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, update PinMetadataUpdate) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := updatePinMetadata(r.Context(), HashMetadataRequest{
+				IpfsPinHash: cid,
+				Name:        update.Name,
+				KeyValues:   update.KeyValues,
+			})
+			if err != nil {
+				loggerFrom(r.Context()).Warn("Pin metadata update failed", "cid", cid, "error", err)
+				response.Results[i] = PinMetadataResult{CID: cid, Error: fmt.Sprintf("Error updating %s: %v", cid, err), Code: uploadErrorCode(err)}
+				return
+			}
+			response.Results[i] = PinMetadataResult{CID: cid, Status: "updated"}
+		}(i, update)
+	}
+	wg.Wait()
+
+	for _, result := range response.Results {
+		if result.Error != "" {
+			response.FailedCount++
+		} else {
+			response.SuccessfulCount++
+		}
+	}
+	loggerFrom(r.Context()).Info("Pin metadata updated", "updated", response.SuccessfulCount, "failed", response.FailedCount)
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.FailedCount > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPinStatusCacheTTL = 30 * time.Second
+
+// pinStatusCacheTTL is how long a /status answer is reused, so clients
+// polling for a CID do not each cost a Pinata request. Zero disables the
+// cache.
+var pinStatusCacheTTL = defaultPinStatusCacheTTL
+
+// PinStatusResponse is the body returned by /status. A CID that is not
+// pinned is reported with pinned false rather than as an error.
+type PinStatusResponse struct {
+	CID        string `json:"cid"`
+	Pinned     bool   `json:"pinned"`
+	PinSize    int    `json:"pin_size,omitempty"`
+	DatePinned string `json:"date_pinned,omitempty"`
+}
+
+type pinStatusEntry struct {
+	status  PinStatusResponse
+	expires time.Time
+}
+
+// pinStatusCache holds recent /status answers by Pinata account and CID.
+var pinStatusCache = struct {
+	mu      sync.Mutex
+	entries map[string]pinStatusEntry
+}{entries: make(map[string]pinStatusEntry)}
+
+// handlePinStatus reports whether the CID in the cid query parameter is
+// pinned by the Pinata account in use.
+func handlePinStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cid := strings.TrimSpace(r.URL.Query().Get("cid"))
+	if cid == "" {
+		sendErrorResponse(w, "Missing cid", http.StatusBadRequest)
+		return
+	}
+	if !isPlausibleCID(cid) {
+		sendErrorResponse(w, fmt.Sprintf("Invalid cid: expected an alphanumeric CID of %d to %d characters", minCIDLength, maxCIDLength), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := requestPinataCredentials(r.Context()); !ok && !hasPinataCredentials() {
+		sendErrorResponse(w, "Pinata credentials are not configured", http.StatusInternalServerError)
+		return
+	}
+
+	key := pinataAccountKey(r.Context()) + "|" + cid
+	pinStatusCache.mu.Lock()
+	entry, ok := pinStatusCache.entries[key]
+	pinStatusCache.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		writePinStatus(w, entry.status)
+		return
+	}
+
+	// hashContains matches substrings, so rows are checked for the exact
+	// CID.
+	pins, err := listPinataPins(r.Context(), url.Values{
+		"hashContains": {cid},
+		"status":       {"pinned"},
+		"pageLimit":    {"10"},
+	})
+	if err != nil {
+		loggerFrom(r.Context()).Error("Pin status lookup failed", "cid", cid, "error", err)
+		sendErrorResponse(w, fmt.Sprintf("Error looking up %s: %v", cid, err), http.StatusBadGateway)
+		return
+	}
+
+	status := PinStatusResponse{CID: cid}
+	for _, row := range pins.Rows {
+		if row.IpfsPinHash == cid {
+			status.Pinned = true
+			status.PinSize = row.Size
+			status.DatePinned = row.DatePinned
+			break
+		}
+	}
+
+	if pinStatusCacheTTL > 0 {
+		pinStatusCache.mu.Lock()
+		for key, entry := range pinStatusCache.entries {
+			if time.Now().After(entry.expires) {
+				delete(pinStatusCache.entries, key)
+			}
+		}
+		pinStatusCache.entries[key] = pinStatusEntry{status: status, expires: time.Now().Add(pinStatusCacheTTL)}
+		pinStatusCache.mu.Unlock()
+	}
+
+	writePinStatus(w, status)
+}
+
+func writePinStatus(w http.ResponseWriter, status PinStatusResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
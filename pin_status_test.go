@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPinStatus(t *testing.T) {
+	setPinataTestConfig(t, 0, time.Minute)
+	const (
+		pinned = "QmPinnedPinnedPinnedPinnedPinnedPinned01"
+		prefix = "QmPrefixPrefixPrefixPrefixPrefixPrefix01"
+	)
+	prevClient := pinataClient
+	var lookups int
+	pinataClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		lookups++
+		// hashContains also matches longer CIDs that contain the one asked
+		// for.
+		cid := req.URL.Query().Get("hashContains")
+		hash := cid
+		if cid == prefix {
+			hash = cid + "x"
+		}
+		return pinataReply(http.StatusOK, fmt.Sprintf(`{"count":1,"rows":[{"ipfs_pin_hash":%q,"size":42,"date_pinned":"2024-01-01T00:00:00Z"}]}`, hash)), nil
+	})}
+	pinStatusCache.mu.Lock()
+	clear(pinStatusCache.entries)
+	pinStatusCache.mu.Unlock()
+	t.Cleanup(func() { pinataClient = prevClient })
+
+	status := func(cid string) PinStatusResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handlePinStatus(rec, httptest.NewRequest(http.MethodGet, "/status?cid="+cid, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status for %s = %d, want 200; body: %s", cid, rec.Code, rec.Body)
+		}
+		var body PinStatusResponse
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	if got := status(pinned); !got.Pinned || got.PinSize != 42 || got.DatePinned == "" {
+		t.Errorf("status for a pinned CID = %+v, want pinned with its size and date", got)
+	}
+	if got := status(prefix); got.Pinned {
+		t.Errorf("status for a CID only matched as a substring = %+v, want not pinned", got)
+	}
+
+	// A repeated lookup is answered from the cache.
+	status(pinned)
+	if lookups != 2 {
+		t.Errorf("Pinata was asked %d times, want 2", lookups)
+	}
+}
+
+func TestPinStatusRejectsInvalidCID(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handlePinStatus(rec, httptest.NewRequest(http.MethodGet, "/status?cid=bad!", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PinListResponse mirrors the body returned by Pinata's pinList endpoint.
+type PinListResponse struct {
+	Count int          `json:"count"`
+	Rows  []PinListRow `json:"rows"`
+}
+
+// PinListRow is a single pin as reported by pinList.
+type PinListRow struct {
+	ID           string         `json:"id"`
+	IpfsPinHash  string         `json:"ipfs_pin_hash"`
+	Size         int            `json:"size"`
+	UserID       string         `json:"user_id"`
+	DatePinned   string         `json:"date_pinned"`
+	DateUnpinned *string        `json:"date_unpinned"`
+	Metadata     PinataMetadata `json:"metadata"`
+}
+
+// PinByHashRequest is the body accepted by /pin-by-hash and forwarded to
+// Pinata's pinByHash endpoint.
+type PinByHashRequest struct {
+	HashToPin      string          `json:"hashToPin"`
+	PinataMetadata *PinataMetadata `json:"pinataMetadata,omitempty"`
+}
+
+// PinByHashResponse is the pin job returned by pinByHash.
+type PinByHashResponse struct {
+	ID       string `json:"id"`
+	IpfsHash string `json:"ipfsHash"`
+	Status   string `json:"status"`
+	Name     string `json:"name"`
+}
+
+// errCIDMismatch is returned when VERIFY_CID is set and the CID Pinata
+// reports does not match the uploaded content.
+var errCIDMismatch = errors.New("CID does not match uploaded content")
+
+// errNotPinned is returned when Pinata reports that a CID is not pinned by
+// the current account.
+var errNotPinned = errors.New("CID is not pinned")
+
+// hasPinataCredentials reports whether either a JWT or a key/secret pair is
+// configured for Pinata.
+func hasPinataCredentials() bool {
+	if os.Getenv("PINATA_JWT") != "" {
+		return true
+	}
+	return os.Getenv("PINATA_API_KEY") != "" && os.Getenv("PINATA_API_SECRET") != ""
+}
+
+// setPinataAuth sets the Pinata authentication headers on req, preferring a
+// JWT bearer token over the legacy key/secret pair. Credentials supplied by
+// the caller, carried in the request context, take precedence over the
+// server's own.
+func setPinataAuth(req *http.Request) {
+	if creds, ok := requestPinataCredentials(req.Context()); ok {
+		if creds.JWT != "" {
+			req.Header.Set("Authorization", "Bearer "+creds.JWT)
+			return
+		}
+		req.Header.Set("pinata_api_key", creds.APIKey)
+		req.Header.Set("pinata_secret_api_key", creds.APISecret)
+		return
+	}
+
+	if jwt := os.Getenv("PINATA_JWT"); jwt != "" {
+		req.Header.Set("Authorization", "Bearer "+jwt)
+		return
+	}
+
+	req.Header.Set("pinata_api_key", os.Getenv("PINATA_API_KEY"))
+	req.Header.Set("pinata_secret_api_key", os.Getenv("PINATA_API_SECRET"))
+}
+
+// pinataEndpoint returns the absolute URL for a Pinata API path.
+func pinataEndpoint(path string) string {
+	return pinataBaseURL + path
+}
+
+// unpinFromPinata removes the pin for cid from the configured account.
+func unpinFromPinata(ctx context.Context, cid string) error {
+	ctx, cancel := context.WithTimeout(ctx, pinataTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, pinataEndpoint("/pinning/unpin/"+url.PathEscape(cid)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	setPinataAuth(req)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := pinataClient.Do(req)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer drainAndClose(resp.Body)
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound || strings.Contains(strings.ToUpper(string(body)), "NOT_PINNED") {
+		return errNotPinned
+	}
+
+	return &pinataStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// listPinataPins queries Pinata's pinList endpoint with the given query
+// parameters.
+func listPinataPins(ctx context.Context, params url.Values) (PinListResponse, error) {
+	var pins PinListResponse
+	err := doPinataJSON(ctx, http.MethodGet, "/data/pinList?"+params.Encode(), nil, &pins)
+	return pins, err
+}
+
+// pinByHash asks Pinata to pin content that is already on the IPFS network.
+func pinByHash(ctx context.Context, request PinByHashRequest) (PinByHashResponse, error) {
+	var pin PinByHashResponse
+	err := doPinataJSON(ctx, http.MethodPost, "/pinning/pinByHash", request, &pin)
+	return pin, err
+}
+
+// pinJSON pins a JSON document with Pinata's pinJSONToIPFS endpoint.
+func pinJSON(ctx context.Context, request PinJSONRequest) (PinataResponse, error) {
+	var response PinataResponse
+	err := doPinataJSON(ctx, http.MethodPost, "/pinning/pinJSONToIPFS", request, &response)
+	return response, err
+}
+
+// updatePinMetadata replaces the name and keyvalues of an existing pin with
+// Pinata's hashMetadata endpoint.
+func updatePinMetadata(ctx context.Context, request HashMetadataRequest) error {
+	return doPinataJSON(ctx, http.MethodPut, "/pinning/hashMetadata", request, nil)
+}
+
+// doPinataJSON sends an authenticated request to a Pinata API path,
+// encoding body as JSON when non-nil and decoding the response into out.
+// Endpoints that answer with plain text are called with a nil out.
+func doPinataJSON(ctx context.Context, method, path string, body, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, pinataTimeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, pinataEndpoint(path), reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	setPinataAuth(req)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := pinataClient.Do(req)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer drainAndClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return &pinataStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode Pinata response: %w", err)
+	}
+
+	return nil
+}
+
+// testPinataAuthentication verifies that the credentials in effect for ctx
+// are accepted by Pinata.
+func testPinataAuthentication(ctx context.Context) error {
+	if _, ok := requestPinataCredentials(ctx); !ok && !hasPinataCredentials() {
+		return errors.New("pinata credentials are not configured")
+	}
+
+	var result struct {
+		Message string `json:"message"`
+	}
+	return doPinataJSON(ctx, http.MethodGet, "/data/testAuthentication", nil, &result)
+}
+
+// PinataProvider stores files by pinning them with Pinata. Client sends the
+// uploads; when nil the shared pinataClient is used, so tests can inject a
+// client pointed at a fake server or wrapping a stub RoundTripper.
+type PinataProvider struct {
+	Client *http.Client
+}
+
+// httpClient returns the client uploads are sent with.
+func (p PinataProvider) httpClient() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return pinataClient
+}
+
+// Upload pins the content read from file under filename, applying any
+// Pinata metadata and options carried by ctx. The multipart body is streamed
+// to Pinata rather than buffered, so retries are only possible when file can
+// be rewound with io.Seeker.
+func (p PinataProvider) Upload(ctx context.Context, filename string, file io.Reader) (UploadResult, error) {
+	opts := uploadOptionsFrom(ctx)
+	seeker, replayable := file.(io.Seeker)
+
+	// With VERIFY_CID the expected CID is computed from the bytes as they
+	// are sent, starting over on every attempt.
+	var expected *unixfsBuilder
+	response, err := postToPinata(ctx, p.httpClient(), filename, replayable, func(writer *multipart.Writer) error {
+		if replayable {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to rewind file: %w", err)
+			}
+		}
+
+		// The leading bytes are read ahead so the part can declare the
+		// file's type, which gateways serve the file with.
+		head := make([]byte, sniffLen)
+		n, err := io.ReadFull(file, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		head = head[:n]
+
+		header := formFileHeader("file", filepath.Base(filename), fileContentType(opts.ContentType, head))
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return fmt.Errorf("failed to create form file: %w", err)
+		}
+
+		var src io.Reader = io.MultiReader(bytes.NewReader(head), file)
+		if verifyCID {
+			expected = newUnixFSBuilder(opts.cidVersion())
+			src = io.TeeReader(src, expected)
+		}
+		_, err = io.Copy(part, src)
+		if err != nil {
+			return fmt.Errorf("failed to copy file content: %w", err)
+		}
+
+		return writePinataFields(writer, filepath.Base(filename), opts)
+	})
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if expected != nil {
+		want, err := expected.CID()
+		if err != nil {
+			return UploadResult{}, err
+		}
+		if cidsDiffer(want, response.IpfsHash) {
+			loggerFrom(ctx).Error("Pinata returned an unexpected CID", "filename", filepath.Base(filename), "cid", response.IpfsHash, "expected_cid", want)
+			return UploadResult{}, fmt.Errorf("%w: Pinata returned %s, expected %s", errCIDMismatch, response.IpfsHash, want)
+		}
+	}
+
+	result := UploadResult{
+		CID:       response.IpfsHash,
+		Size:      response.PinSize,
+		Timestamp: response.Timestamp,
+	}
+	if opts.Options != nil {
+		result.GroupID = opts.Options.GroupID
+	}
+	return result, nil
+}
+
+// quoteEscaper escapes form-data parameter values the way
+// multipart.Writer.CreateFormFile does.
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// formFileHeader is the header CreateFormFile would write, but with the
+// given content type instead of always application/octet-stream.
+func formFileHeader(field, filename, contentType string) textproto.MIMEHeader {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, quoteEscaper.Replace(field), quoteEscaper.Replace(filename)))
+	header.Set("Content-Type", contentType)
+	return header
+}
+
+// fileContentType returns the type to send a file as: the type the client
+// declared, unless it is missing, malformed or the generic
+// application/octet-stream, and otherwise the type sniffed from the file's
+// leading bytes. Unrecognised content is sent as application/octet-stream.
+func fileContentType(declared string, head []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return declared
+	}
+	return http.DetectContentType(head)
+}
+
+// writePinataFields adds the pinataMetadata and, when set, pinataOptions
+// parts to an upload body. defaultName is used when no name was supplied.
+func writePinataFields(writer *multipart.Writer, defaultName string, opts uploadOptions) error {
+	var metadata PinataMetadata
+	if opts.Metadata != nil {
+		metadata = *opts.Metadata
+	}
+	if metadata.Name == "" {
+		metadata.Name = defaultName
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode pinata metadata: %w", err)
+	}
+
+	err = writer.WriteField("pinataMetadata", string(metadataJSON))
+	if err != nil {
+		return fmt.Errorf("failed to write pinata metadata: %w", err)
+	}
+
+	if opts.Options != nil {
+		optionsJSON, err := json.Marshal(opts.Options)
+		if err != nil {
+			return fmt.Errorf("failed to encode pinata options: %w", err)
+		}
+
+		err = writer.WriteField("pinataOptions", string(optionsJSON))
+		if err != nil {
+			return fmt.Errorf("failed to write pinata options: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// postToPinata streams an upload body produced by writeBody to Pinata with
+// client, retrying transient failures with backoff when replayable is true.
+// The body is regenerated for every attempt. name identifies the upload in
+// logs.
+func postToPinata(ctx context.Context, client *http.Client, name string, replayable bool, writeBody func(*multipart.Writer) error) (PinataResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if err := pinataBreaker.allow(); err != nil {
+			return PinataResponse{}, err
+		}
+		pinataResp, err := sendPinataRequest(ctx, client, writeBody)
+		switch {
+		case err == nil:
+			pinataBreaker.record(false)
+		case ctx.Err() != nil:
+			// The caller gave up, which says nothing about Pinata.
+			pinataBreaker.release()
+		case isRetryable(err):
+			pinataBreaker.record(true)
+		default:
+			// Client errors such as bad credentials say nothing about
+			// whether Pinata is up, so they neither count against it nor
+			// close the circuit.
+			pinataBreaker.release()
+		}
+		if err == nil {
+			return pinataResp, nil
+		}
+		if attempt >= pinataMaxRetries || !replayable || !isRetryable(err) || ctx.Err() != nil {
+			return PinataResponse{}, err
+		}
+
+		delay := retryDelay(attempt, err)
+		var statusErr *pinataStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+			loggerFrom(ctx).Warn("Rate limited by Pinata, backing off", "filename", name, "attempt", attempt+1, "max_retries", pinataMaxRetries, "retry_after", statusErr.RetryAfter, "delay", delay)
+		} else {
+			loggerFrom(ctx).Warn("Retrying upload", "filename", name, "attempt", attempt+1, "max_retries", pinataMaxRetries, "delay", delay, "error", err)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return PinataResponse{}, fmt.Errorf("upload canceled: %w", err)
+		}
+	}
+}
+
+// sendPinataRequest performs a single upload attempt. writeBody runs in its
+// own goroutine feeding an io.Pipe, so file content flows to Pinata without
+// being held in memory. The attempt, including reading the response body, is
+// bounded by pinataTimeout.
+func sendPinataRequest(ctx context.Context, client *http.Client, writeBody func(*multipart.Writer) error) (PinataResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, pinataTimeout)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	writeErr := make(chan error, 1)
+	go func() {
+		err := writeBody(writer)
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+		writeErr <- err
+	}()
+
+	pinataResp, err := doPinataUpload(ctx, client, pr, writer.FormDataContentType())
+
+	// Closing the read side unblocks the writer if the request ended early.
+	pr.Close()
+	if werr := <-writeErr; werr != nil && !errors.Is(werr, io.ErrClosedPipe) {
+		return PinataResponse{}, werr
+	}
+
+	return pinataResp, err
+}
+
+// doPinataUpload posts body to the Pinata upload endpoint and decodes the
+// response.
+func doPinataUpload(ctx context.Context, client *http.Client, body io.Reader, contentType string) (PinataResponse, error) {
+	pinataAPIURL := os.Getenv("PINATA_API_URL")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", pinataAPIURL, body)
+	if err != nil {
+		return PinataResponse{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	setPinataAuth(req)
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return PinataResponse{}, requestError(ctx, err)
+	}
+	defer drainAndClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		loggerFrom(ctx).Warn("Pinata upload returned non-OK status", "status_code", resp.StatusCode)
+		return PinataResponse{}, &pinataStatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	var pinataResp PinataResponse
+	err = json.NewDecoder(resp.Body).Decode(&pinataResp)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return PinataResponse{}, requestError(ctx, err)
+		}
+		return PinataResponse{}, fmt.Errorf("failed to decode Pinata response: %w", err)
+	}
+
+	return pinataResp, nil
+}
+
+// requestError classifies a failed Pinata request, reporting timeouts
+// distinctly from other network errors.
+func requestError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &retryableError{fmt.Errorf("upload timed out after %ds", int(pinataTimeout.Seconds()))}
+	}
+	return &retryableError{fmt.Errorf("failed to send request: %w", err)}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifiedCredentialsTTL is how long per-request credentials that Pinata
+// accepted are trusted before they are checked again.
+const verifiedCredentialsTTL = 5 * time.Minute
+
+// pinataCredentials are Pinata credentials supplied by the caller instead of
+// the server's own, for multi-tenant deployments.
+type pinataCredentials struct {
+	JWT       string
+	APIKey    string
+	APISecret string
+}
+
+type pinataCredentialsKey struct{}
+
+// requestPinataCredentials returns the caller's Pinata credentials from ctx,
+// if the request supplied any.
+func requestPinataCredentials(ctx context.Context) (pinataCredentials, bool) {
+	creds, ok := ctx.Value(pinataCredentialsKey{}).(pinataCredentials)
+	return creds, ok
+}
+
+// pinataCredentialsFromHeaders reads per-request credentials. A Pinata JWT
+// is taken from the pinata_jwt header, or from Authorization when this
+// server does not use that header for its own tokens. found is false when
+// the request carries no Pinata credentials at all.
+func pinataCredentialsFromHeaders(r *http.Request) (creds pinataCredentials, found bool, err error) {
+	creds.APIKey = strings.TrimSpace(r.Header.Get("pinata_api_key"))
+	creds.APISecret = strings.TrimSpace(r.Header.Get("pinata_secret_api_key"))
+	creds.JWT = strings.TrimSpace(r.Header.Get("pinata_jwt"))
+	if creds.JWT == "" && !jwtAuthEnabled() && r.Header.Get("Authorization") != "" {
+		if creds.JWT, err = bearerToken(r); err != nil {
+			return pinataCredentials{}, true, err
+		}
+	}
+
+	switch {
+	case creds.JWT != "":
+		return pinataCredentials{JWT: creds.JWT}, true, nil
+	case creds.APIKey != "" && creds.APISecret != "":
+		return creds, true, nil
+	case creds.APIKey != "" || creds.APISecret != "":
+		return pinataCredentials{}, true, errors.New("both pinata_api_key and pinata_secret_api_key are required")
+	default:
+		return pinataCredentials{}, false, nil
+	}
+}
+
+// pinataAccountKey identifies the Pinata account requests carrying ctx act
+// on, for caches that must not leak results between accounts. It is empty
+// for the server's own credentials.
+func pinataAccountKey(ctx context.Context) string {
+	creds, ok := requestPinataCredentials(ctx)
+	if !ok {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(creds.JWT + "\x00" + creds.APIKey + "\x00" + creds.APISecret))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifiedCredentials remembers hashes of per-request credentials that
+// Pinata recently accepted, so each request does not pay for a check.
+var verifiedCredentials = struct {
+	mu      sync.Mutex
+	expires map[[sha256.Size]byte]time.Time
+}{expires: make(map[[sha256.Size]byte]time.Time)}
+
+// verifyPinataCredentials checks the credentials in ctx against Pinata,
+// consulting the cache of recent successes first.
+func verifyPinataCredentials(ctx context.Context, creds pinataCredentials) error {
+	sum := sha256.Sum256([]byte(creds.JWT + "\x00" + creds.APIKey + "\x00" + creds.APISecret))
+
+	verifiedCredentials.mu.Lock()
+	expires, ok := verifiedCredentials.expires[sum]
+	verifiedCredentials.mu.Unlock()
+	if ok && time.Now().Before(expires) {
+		return nil
+	}
+
+	if err := testPinataAuthentication(ctx); err != nil {
+		return err
+	}
+
+	verifiedCredentials.mu.Lock()
+	defer verifiedCredentials.mu.Unlock()
+	for key, expires := range verifiedCredentials.expires {
+		if time.Now().After(expires) {
+			delete(verifiedCredentials.expires, key)
+		}
+	}
+	verifiedCredentials.expires[sum] = time.Now().Add(verifiedCredentialsTTL)
+	return nil
+}
+
+// pinataCredentialsMiddleware lets callers use their own Pinata account by
+// sending credentials in headers. Requests without them fall through to the
+// server's configured credentials. Partial credentials, or ones Pinata
+// rejects, are answered with 401. Header values are never logged.
+func pinataCredentialsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds, found, err := pinataCredentialsFromHeaders(r)
+		if !found {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			loggerFrom(r.Context()).Warn("Rejected request with malformed Pinata credentials", "path", r.URL.Path, "error", err)
+			sendErrorResponse(w, "Invalid Pinata credentials: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// Dry-run uploads never reach Pinata, so the credentials are only
+		// checked for shape.
+		ctx := context.WithValue(r.Context(), pinataCredentialsKey{}, creds)
+		if r.URL.Path == "/upload" && isDryRun(r) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if err := verifyPinataCredentials(ctx, creds); err != nil {
+			var statusErr *pinataStatusError
+			if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnauthorized || statusErr.StatusCode == http.StatusForbidden) {
+				loggerFrom(ctx).Warn("Rejected request with invalid Pinata credentials", "path", r.URL.Path)
+				sendErrorResponse(w, "Invalid Pinata credentials", http.StatusUnauthorized)
+				return
+			}
+			loggerFrom(ctx).Warn("Failed to verify Pinata credentials", "path", r.URL.Path, "error", err)
+			sendErrorResponse(w, "Failed to verify Pinata credentials", http.StatusBadGateway)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
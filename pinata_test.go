@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripFunc adapts a function to http.RoundTripper, so tests can stand
+// in for Pinata without a network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// pinataReply builds a response from the stub Pinata.
+func pinataReply(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// multipartFile returns the content of the file part of a request to the
+// stub Pinata.
+func multipartFile(req *http.Request) (string, error) {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return "", err
+	}
+	form, err := multipart.NewReader(req.Body, params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		return "", err
+	}
+	fhs := form.File["file"]
+	if len(fhs) != 1 {
+		return "", fmt.Errorf("request has %d file parts, want 1", len(fhs))
+	}
+	f, err := fhs[0].Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return string(data), err
+}
+
+const pinataOK = `{"IpfsHash":"QmTest","PinSize":42,"Timestamp":"2024-01-01T00:00:00Z"}`
+
+// setPinataTestConfig points uploads at a fake Pinata URL with test
+// credentials, overrides the retry and timeout settings and disables the
+// shared circuit breaker for the rest of the test.
+func setPinataTestConfig(t *testing.T, maxRetries int, timeout time.Duration) {
+	t.Helper()
+	t.Setenv("PINATA_API_URL", "http://pinata.test/pinning/pinFileToIPFS")
+	t.Setenv("PINATA_JWT", "test-jwt")
+	prevRetries, prevTimeout, prevBreaker := pinataMaxRetries, pinataTimeout, pinataBreaker
+	pinataMaxRetries, pinataTimeout, pinataBreaker = maxRetries, timeout, &circuitBreaker{}
+	t.Cleanup(func() {
+		pinataMaxRetries, pinataTimeout, pinataBreaker = prevRetries, prevTimeout, prevBreaker
+	})
+}
+
+// generatedReader yields remaining bytes of generated content without ever
+// holding more than the caller's buffer.
+type generatedReader struct {
+	remaining int64
+}
+
+func (r *generatedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), r.remaining))
+	for i := range p[:n] {
+		p[i] = byte(i)
+	}
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+func TestPinataUploadStreamsLargeFile(t *testing.T) {
+	setPinataTestConfig(t, 0, time.Minute)
+
+	const size = 128 << 20
+	var received int64
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		n, err := io.Copy(io.Discard, req.Body)
+		if err != nil {
+			return nil, err
+		}
+		received = n
+		return pinataReply(http.StatusOK, pinataOK), nil
+	})}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	result, err := PinataProvider{Client: client}.Upload(context.Background(), "big.bin", &generatedReader{remaining: size})
+	runtime.ReadMemStats(&after)
+
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if result.CID != "QmTest" {
+		t.Errorf("CID = %q, want QmTest", result.CID)
+	}
+	if received < size {
+		t.Errorf("Pinata received %d bytes, want at least the %d byte file", received, size)
+	}
+	// Buffering the file would allocate at least its size.
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Errorf("uploading a %d byte file allocated %d bytes, want it streamed", size, allocated)
+	}
+}
+
+func TestPinataUploadSuccess(t *testing.T) {
+	setPinataTestConfig(t, 0, time.Minute)
+
+	var file, metadata string
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method != http.MethodPost || req.URL.String() != "http://pinata.test/pinning/pinFileToIPFS" {
+			t.Errorf("request = %s %s, want POST to PINATA_API_URL", req.Method, req.URL)
+		}
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, err
+		}
+		form, err := multipart.NewReader(req.Body, params["boundary"]).ReadForm(1 << 20)
+		if err != nil {
+			return nil, err
+		}
+		if fhs := form.File["file"]; len(fhs) == 1 {
+			f, _ := fhs[0].Open()
+			data, _ := io.ReadAll(f)
+			f.Close()
+			file = string(data)
+		}
+		metadata = form.Value["pinataMetadata"][0]
+		return pinataReply(http.StatusOK, pinataOK), nil
+	})}
+
+	result, err := PinataProvider{Client: client}.Upload(context.Background(), "hello.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if result.CID != "QmTest" || result.Size != 42 {
+		t.Errorf("result = %+v, want QmTest of 42 bytes", result)
+	}
+	if file != "hello" {
+		t.Errorf("Pinata received file %q, want %q", file, "hello")
+	}
+	if metadata != `{"name":"hello.txt"}` {
+		t.Errorf("pinataMetadata = %s, want the filename as name", metadata)
+	}
+}
+
+func TestPinataUploadFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		reply      func(*http.Request) (*http.Response, error)
+		maxRetries int
+		timeout    time.Duration
+		attempts   int64
+		check      func(*testing.T, error)
+	}{
+		{
+			name:       "client error is not retried",
+			reply:      replyStatus(http.StatusUnauthorized),
+			maxRetries: 2,
+			attempts:   1,
+			check: func(t *testing.T, err error) {
+				var statusErr *pinataStatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+					t.Errorf("error = %v, want a 401 status error", err)
+				}
+				if code := uploadErrorCode(err); code != CodeUploadFailed {
+					t.Errorf("code = %s, want %s", code, CodeUploadFailed)
+				}
+			},
+		},
+		{
+			name:       "server error is retried",
+			reply:      replyStatus(http.StatusServiceUnavailable),
+			maxRetries: 1,
+			attempts:   2,
+			check: func(t *testing.T, err error) {
+				var statusErr *pinataStatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+					t.Errorf("error = %v, want a 503 status error", err)
+				}
+				if code := uploadErrorCode(err); code != CodePinataUnavailable {
+					t.Errorf("code = %s, want %s", code, CodePinataUnavailable)
+				}
+			},
+		},
+		{
+			name: "timeout",
+			reply: func(req *http.Request) (*http.Response, error) {
+				<-req.Context().Done()
+				return nil, req.Context().Err()
+			},
+			timeout:  50 * time.Millisecond,
+			attempts: 1,
+			check: func(t *testing.T, err error) {
+				if err == nil || !strings.Contains(err.Error(), "timed out") || !isRetryable(err) {
+					t.Errorf("error = %v, want a retryable timeout", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPinataTestConfig(t, tt.maxRetries, cmp.Or(tt.timeout, time.Minute))
+
+			var attempts atomic.Int64
+			client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				attempts.Add(1)
+				io.Copy(io.Discard, req.Body)
+				return tt.reply(req)
+			})}
+
+			_, err := PinataProvider{Client: client}.Upload(context.Background(), "hello.txt", bytes.NewReader([]byte("hello")))
+			if err == nil {
+				t.Fatal("Upload succeeded, want an error")
+			}
+			tt.check(t, err)
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("Pinata was called %d times, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+// replyStatus answers every request with an error status.
+func replyStatus(status int) func(*http.Request) (*http.Response, error) {
+	return func(*http.Request) (*http.Response, error) {
+		return pinataReply(status, `{"error":"`+http.StatusText(status)+`"}`), nil
+	}
+}
+
+func TestPinataRequestsCarryUserAgent(t *testing.T) {
+	setPinataTestConfig(t, 0, time.Minute)
+	prevUserAgent, prevClient := userAgent, pinataClient
+	userAgent = "fileupload/1.2.3 (commit abc123)"
+	t.Cleanup(func() { userAgent, pinataClient = prevUserAgent, prevClient })
+
+	seen := make(map[string]string)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			io.Copy(io.Discard, req.Body)
+		}
+		seen[req.Method+" "+req.URL.Path] = req.Header.Get("User-Agent")
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/data/pinList"):
+			return pinataReply(http.StatusOK, `{"count":0,"rows":[]}`), nil
+		case strings.HasPrefix(req.URL.Path, "/data/testAuthentication"):
+			return pinataReply(http.StatusOK, `{"message":"ok"}`), nil
+		default:
+			return pinataReply(http.StatusOK, pinataOK), nil
+		}
+	})}
+	pinataClient = client
+
+	ctx := context.Background()
+	if _, err := (PinataProvider{Client: client}).Upload(ctx, "hello.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if err := unpinFromPinata(ctx, "QmTest"); err != nil {
+		t.Fatalf("unpin: %v", err)
+	}
+	if _, err := listPinataPins(ctx, nil); err != nil {
+		t.Fatalf("pinList: %v", err)
+	}
+	if err := testPinataAuthentication(ctx); err != nil {
+		t.Fatalf("testAuthentication: %v", err)
+	}
+
+	if len(seen) != 4 {
+		t.Fatalf("requests = %v, want upload, unpin, list and readiness", seen)
+	}
+	for request, got := range seen {
+		if got != userAgent {
+			t.Errorf("%s sent User-Agent %q, want %q", request, got, userAgent)
+		}
+	}
+}
+
+func TestPinataUploadSendsContentType(t *testing.T) {
+	setPinataTestConfig(t, 0, time.Minute)
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 16)
+
+	tests := []struct {
+		name     string
+		declared string
+		content  string
+		want     string
+	}{
+		{"declared type is kept", "image/svg+xml", "<svg/>", "image/svg+xml"},
+		{"generic type is sniffed", "application/octet-stream", png, "image/png"},
+		{"missing type is sniffed", "", "hello", "text/plain; charset=utf-8"},
+		{"malformed type is sniffed", "not a type", png, "image/png"},
+		{"unrecognised content", "", "\x00\x01\x02\x03", "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+				if err != nil {
+					return nil, err
+				}
+				mr := multipart.NewReader(req.Body, params["boundary"])
+				for {
+					part, err := mr.NextPart()
+					if err != nil {
+						break
+					}
+					if part.FormName() == "file" {
+						got = part.Header.Get("Content-Type")
+					}
+				}
+				return pinataReply(http.StatusOK, pinataOK), nil
+			})}
+
+			ctx := withUploadOptions(context.Background(), uploadOptions{ContentType: tt.declared})
+			if _, err := (PinataProvider{Client: client}).Upload(ctx, "file", strings.NewReader(tt.content)); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("file part Content-Type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
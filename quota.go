@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiKeyQuota limits what one API key may pin per calendar month (UTC).
+// A zero limit is unlimited.
+type apiKeyQuota struct {
+	Bytes int64
+	Pins  int64
+}
+
+// apiKeyQuotas maps API keys to their quotas. Keys without an entry, and
+// requests authenticated with a token, are not limited.
+var apiKeyQuotas map[string]apiKeyQuota
+
+// QuotaUsage is an API key's usage in the current period. Limits and
+// remaining allowances are omitted for unlimited dimensions.
+type QuotaUsage struct {
+	Period         string    `json:"period"`
+	ResetsAt       time.Time `json:"resets_at"`
+	BytesUsed      int64     `json:"bytes_used"`
+	BytesLimit     *int64    `json:"bytes_limit,omitempty"`
+	BytesRemaining *int64    `json:"bytes_remaining,omitempty"`
+	PinsUsed       int64     `json:"pins_used"`
+	PinsLimit      *int64    `json:"pins_limit,omitempty"`
+	PinsRemaining  *int64    `json:"pins_remaining,omitempty"`
+}
+
+// QuotaExceededResponse is the 429 body for a key that has used up its
+// quota.
+type QuotaExceededResponse struct {
+	Error     string     `json:"error"`
+	Code      ErrorCode  `json:"code"`
+	RequestID string     `json:"request_id,omitempty"`
+	Quota     QuotaUsage `json:"quota"`
+}
+
+// parseAPIKeyQuotas parses API_KEY_QUOTAS entries of the form KEY=BYTES/PINS,
+// such as "k1=10GB/1000". Either limit may be left empty to leave it
+// unlimited.
+func parseAPIKeyQuotas(entries []string) (map[string]apiKeyQuota, error) {
+	quotas := make(map[string]apiKeyQuota, len(entries))
+	for _, entry := range entries {
+		// Keys may end in base64 padding, so the last "=" separates them.
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("entry for key %s must be KEY=BYTES/PINS", keySuffix(entry))
+		}
+		key, limits := entry[:i], entry[i+1:]
+		rawBytes, rawPins, ok := strings.Cut(limits, "/")
+		if !ok {
+			return nil, fmt.Errorf("entry for key %s must be KEY=BYTES/PINS", keySuffix(key))
+		}
+
+		var quota apiKeyQuota
+		var err error
+		if rawBytes = strings.TrimSpace(rawBytes); rawBytes != "" {
+			if quota.Bytes, err = parseSize(rawBytes); err != nil {
+				return nil, fmt.Errorf("entry for key %s: %w", keySuffix(key), err)
+			}
+		}
+		if rawPins = strings.TrimSpace(rawPins); rawPins != "" {
+			quota.Pins, err = strconv.ParseInt(rawPins, 10, 64)
+			if err != nil || quota.Pins <= 0 {
+				return nil, fmt.Errorf("entry for key %s: pin limit must be a positive integer", keySuffix(key))
+			}
+		}
+		quotas[key] = quota
+	}
+	return quotas, nil
+}
+
+// quotaPeriod returns the period containing now and when it ends.
+func quotaPeriod(now time.Time) (string, time.Time) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start.Format("2006-01"), start.AddDate(0, 1, 0)
+}
+
+// quotaKeyHash identifies an API key in the database without storing it.
+func quotaKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// quotaFor returns the API key the request carrying ctx authenticated with
+// and its quota, if one applies.
+func quotaFor(ctx context.Context) (string, apiKeyQuota, bool) {
+	if store == nil {
+		return "", apiKeyQuota{}, false
+	}
+	key := apiKeyFrom(ctx)
+	quota, ok := apiKeyQuotas[key]
+	return key, quota, ok && key != ""
+}
+
+// currentUsage reads key's usage in the current period.
+func currentUsage(ctx context.Context, key string, quota apiKeyQuota) (QuotaUsage, error) {
+	period, resetsAt := quotaPeriod(time.Now())
+	bytes, pins, err := store.usage(ctx, quotaKeyHash(key), period)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	usage := QuotaUsage{Period: period, ResetsAt: resetsAt, BytesUsed: bytes, PinsUsed: pins}
+	if quota.Bytes > 0 {
+		usage.BytesLimit = &quota.Bytes
+		remaining := max(quota.Bytes-bytes, 0)
+		usage.BytesRemaining = &remaining
+	}
+	if quota.Pins > 0 {
+		usage.PinsLimit = &quota.Pins
+		remaining := max(quota.Pins-pins, 0)
+		usage.PinsRemaining = &remaining
+	}
+	return usage, nil
+}
+
+// exceeded reports whether either allowance is used up.
+func (u QuotaUsage) exceeded() bool {
+	return u.BytesRemaining != nil && *u.BytesRemaining == 0 ||
+		u.PinsRemaining != nil && *u.PinsRemaining == 0
+}
+
+// enforceQuota rejects requests from API keys that have used up their quota
+// for the period. Usage is only checked before a request starts, so
+// concurrent requests may overshoot the limit by what they pin.
+func enforceQuota(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, quota, ok := quotaFor(r.Context())
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		usage, err := currentUsage(r.Context(), key, quota)
+		if err != nil {
+			loggerFrom(r.Context()).Error("Failed to read quota usage", "key_suffix", keySuffix(key), "error", err)
+			sendErrorResponse(w, "Failed to check upload quota", http.StatusInternalServerError)
+			return
+		}
+		if usage.exceeded() {
+			loggerFrom(r.Context()).Warn("Rejected request over quota", "path", r.URL.Path, "key_suffix", keySuffix(key), "bytes_used", usage.BytesUsed, "pins_used", usage.PinsUsed)
+			retryIn := time.Until(usage.ResetsAt)
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(retryIn.Seconds())), 1)))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(QuotaExceededResponse{
+				Error:     fmt.Sprintf("Upload quota exceeded for %s, it resets at %s", usage.Period, usage.ResetsAt.Format(time.RFC3339)),
+				Code:      CodeQuotaExceeded,
+				RequestID: w.Header().Get("X-Request-ID"),
+				Quota:     usage,
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// chargeQuota adds one pin of size bytes to the usage of the API key the
+// request carrying ctx authenticated with. Like recordUpload, failures are
+// only logged.
+func chargeQuota(ctx context.Context, size int64) {
+	key, _, ok := quotaFor(ctx)
+	if !ok {
+		return
+	}
+
+	period, _ := quotaPeriod(time.Now())
+	if err := store.addUsage(context.WithoutCancel(ctx), quotaKeyHash(key), period, size, 1); err != nil {
+		loggerFrom(ctx).Error("Failed to record quota usage", "key_suffix", keySuffix(key), "error", err)
+	}
+}
+
+// handleQuota reports the caller's usage and remaining allowance.
+func handleQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, quota, ok := quotaFor(r.Context())
+	if !ok {
+		sendErrorResponse(w, "No quota applies to this request", http.StatusNotFound)
+		return
+	}
+
+	usage, err := currentUsage(r.Context(), key, quota)
+	if err != nil {
+		loggerFrom(r.Context()).Error("Failed to read quota usage", "key_suffix", keySuffix(key), "error", err)
+		sendErrorResponse(w, "Failed to read quota usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(usage)
+}
+
+// validateAPIKeyQuotas checks that quotas only name configured keys, so a
+// mistyped key does not silently leave the real one unlimited.
+func validateAPIKeyQuotas(quotas map[string]apiKeyQuota, keys []string) error {
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	var errs []error
+	for key := range quotas {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("quota for key %s does not match any of API_KEYS or ADMIN_API_KEYS", keySuffix(key)))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitIdleTTL is how long a client can stay idle before its bucket is
+// evicted.
+const rateLimitIdleTTL = 3 * time.Minute
+
+// visitor is the token bucket for a single client IP.
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter applies a token-bucket limit per client IP.
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	visitors map[string]*visitor
+	rps      rate.Limit
+	burst    int
+}
+
+// newIPRateLimiter creates a limiter allowing rps requests per second with
+// the given burst per IP, and starts evicting idle clients in the
+// background. A non-positive rps disables limiting.
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	l := &ipRateLimiter{
+		visitors: make(map[string]*visitor),
+		rps:      rate.Limit(rps),
+		burst:    burst,
+	}
+	if rps > 0 {
+		go l.evictIdle()
+	}
+	return l
+}
+
+// limiterFor returns the bucket for ip, creating it on first use.
+func (l *ipRateLimiter) limiterFor(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	v, ok := l.visitors[ip]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.visitors[ip] = v
+	}
+	v.lastSeen = time.Now()
+	return v.limiter
+}
+
+// evictIdle periodically drops buckets for clients that have gone quiet so
+// the map does not grow without bound.
+func (l *ipRateLimiter) evictIdle() {
+	ticker := time.NewTicker(rateLimitIdleTTL / 3)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		l.mu.Lock()
+		for ip, v := range l.visitors {
+			if time.Since(v.lastSeen) > rateLimitIdleTTL {
+				delete(l.visitors, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// middleware rejects requests over the limit with 429 and a Retry-After
+// header.
+func (l *ipRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.rps <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		reservation := l.limiterFor(ip).Reserve()
+		if delay := reservation.Delay(); !reservation.OK() || delay > 0 {
+			reservation.Cancel()
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if !reservation.OK() || retryAfter < 1 {
+				retryAfter = 1
+			}
+			loggerFrom(r.Context()).Warn("Rate limit exceeded", "client_ip", ip, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			sendErrorResponse(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For header is
+// believed. Requests from any other peer are identified by the peer's own
+// address, so clients cannot pick their identity by setting the header.
+// Peers on a Unix socket are always trusted: the socket carries no client
+// address, and only the proxy permitted to open the socket file can connect.
+var trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses TRUSTED_PROXIES entries, each an IP address or
+// a CIDR range.
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// isTrustedProxy reports whether ip is one of trustedProxies.
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// viaUnixSocket reports whether r arrived on a Unix socket listener.
+func viaUnixSocket(r *http.Request) bool {
+	_, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	return ok
+}
+
+// clientIP returns the originating client address. X-Forwarded-For is only
+// consulted when the peer is a trusted proxy, and then the right-most hop
+// that is not itself a trusted proxy is used: entries further left were
+// supplied by the client and can be anything.
+func clientIP(r *http.Request) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrustedProxy(peer) && !viaUnixSocket(r) {
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(hop) {
+			return hop
+		}
+		// Every hop so far is a trusted proxy, so the next one left is the
+		// best candidate if the chain ends here.
+		peer = hop
+	}
+	return peer
+}
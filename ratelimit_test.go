@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// serveUnixSocket serves handler on a Unix socket for the rest of the test
+// and returns a client that connects to it.
+func serveUnixSocket(t *testing.T, handler http.Handler) *http.Client {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "server.sock")
+	listener, err := listen(unixSocketPrefix + path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestRateLimitUsesForwardedClientOnUnixSocket(t *testing.T) {
+	limiter := newIPRateLimiter(0.001, 1)
+	var peers []string
+	client := serveUnixSocket(t, limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peers = append(peers, r.RemoteAddr)
+	})))
+
+	get := func(forwardedFor string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://unix/", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := get("203.0.113.1"); status != http.StatusOK {
+		t.Fatalf("first request from 203.0.113.1 = %d, want 200", status)
+	}
+	if status := get("203.0.113.2"); status != http.StatusOK {
+		t.Errorf("first request from 203.0.113.2 = %d, want 200 from its own bucket", status)
+	}
+	if status := get("203.0.113.1"); status != http.StatusTooManyRequests {
+		t.Errorf("second request from 203.0.113.1 = %d, want 429", status)
+	}
+	for _, peer := range peers {
+		if peer != "@" {
+			t.Errorf("RemoteAddr = %q, want the unnamed socket peer @", peer)
+		}
+	}
+}
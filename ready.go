@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyCacheTTL is how long a readiness result is reused before Pinata is
+// contacted again.
+const readyCacheTTL = 5 * time.Second
+
+// readinessCache memoizes the most recent Pinata connectivity check.
+type readinessCache struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+var readiness readinessCache
+
+// check returns the cached result when it is fresh, otherwise it re-runs the
+// Pinata authentication test. Providers other than Pinata have no remote
+// dependency and are always ready. Concurrent callers share a single check.
+func (c *readinessCache) check(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < readyCacheTTL {
+		return c.err
+	}
+
+	c.err = nil
+	if _, ok := storage.(PinataProvider); ok {
+		c.err = testPinataAuthentication(ctx)
+	}
+	c.checkedAt = time.Now()
+	return c.err
+}
+
+// handleReady is a readiness probe that succeeds only when Pinata accepts
+// the configured credentials, the circuit breaker is not open, and uploads
+// have not recently run out of temp space. A breaker whose cooldown has
+// elapsed counts as ready: only an upload moves it to half-open, and a node
+// drained for being unready would otherwise never receive one.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := checkSpillSpace(); err != nil {
+		sendCodedError(w, CodeInsufficientStorage, "Upload temp directory is full: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if state, retryIn := pinataBreaker.status(); state == breakerOpen && retryIn > 0 {
+		sendCodedError(w, CodePinataUnavailable, "Pinata circuit breaker is open", http.StatusServiceUnavailable)
+		return
+	}
+	if err := readiness.check(r.Context()); err != nil {
+		sendCodedError(w, CodePinataUnavailable, "Pinata is not reachable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	state, _ := pinataBreaker.status()
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready", "circuit_breaker": state.String()})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// secondaryStorageProvider names the provider selected by
+// SECONDARY_PROVIDER, and secondaryStorage is its implementation. Every
+// file is also stored there when it is set.
+var (
+	secondaryStorageProvider string
+	secondaryStorage         StorageProvider
+
+	// requireBothProviders fails an upload when the secondary copy fails,
+	// instead of only reporting it.
+	requireBothProviders bool
+)
+
+// uploadRedundant stores data with the primary (or fallback) and secondary
+// providers concurrently. Both read from the same buffer, so the file is
+// only read once. A secondary failure is recorded on the result and only
+// returned as an error when requireBothProviders is set.
+func uploadRedundant(ctx context.Context, filename string, data []byte) (UploadResult, error) {
+	var secondary UploadResult
+	var secondaryErr error
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		secondary, secondaryErr = secondaryStorage.Upload(ctx, filename, bytes.NewReader(data))
+	}()
+
+	result, err := uploadWithFallback(ctx, filename, data)
+	wg.Wait()
+	if err != nil {
+		return result, err
+	}
+
+	if secondaryErr != nil {
+		if requireBothProviders {
+			return UploadResult{}, fmt.Errorf("secondary provider %s: %w", secondaryStorageProvider, secondaryErr)
+		}
+		loggerFrom(ctx).Warn("Secondary upload failed", "filename", filename, "provider", secondaryStorageProvider, "error", secondaryErr)
+		result.SecondaryErr = secondaryErr
+		return result, nil
+	}
+
+	result.SecondaryCID = secondary.CID
+	if cidsDiffer(result.CID, secondary.CID) {
+		loggerFrom(ctx).Warn("Providers returned different CIDs", "filename", filename, "cid", result.CID, "secondary_cid", secondary.CID)
+	}
+	return result, nil
+}
+
+// cidsDiffer reports whether two CIDs identify different content, treating
+// the CIDv0 and CIDv1 forms of the same hash as equal.
+func cidsDiffer(a, b string) bool {
+	if a == b {
+		return false
+	}
+
+	ca, errA := cid.Decode(a)
+	cb, errB := cid.Decode(b)
+	if errA != nil || errB != nil {
+		return true
+	}
+	return !cid.NewCidV1(ca.Type(), ca.Hash()).Equals(cid.NewCidV1(cb.Type(), cb.Hash()))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// repinCheckInterval is how often recorded uploads are checked against
+// Pinata. Zero disables the check.
+var repinCheckInterval time.Duration
+
+// runRepinChecks periodically verifies that every recorded upload is still
+// pinned, re-pinning any that have gone missing, until ctx is done.
+func runRepinChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkPins(ctx)
+		}
+	}
+}
+
+// checkPins runs a single pass over the recorded uploads. Only uploads
+// pinned with the server's own credentials are checked: those are the
+// credentials pins are listed and restored with, and content a caller pinned
+// into their own account must not be re-pinned into the server's.
+func checkPins(ctx context.Context) {
+	records, err := store.pinnedUploads(ctx, "")
+	if err != nil {
+		logger.Error("Re-pin check could not read uploads", "error", err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	pinned, err := pinnedCIDs(ctx)
+	if err != nil {
+		logger.Error("Re-pin check could not list pins", "error", err)
+		return
+	}
+
+	var repinned int
+	for _, rec := range records {
+		if ctx.Err() != nil {
+			return
+		}
+		if pinned[rec.CID] {
+			continue
+		}
+
+		_, err = pinByHash(ctx, PinByHashRequest{
+			HashToPin:      rec.CID,
+			PinataMetadata: &PinataMetadata{Name: rec.Filename},
+		})
+		if err != nil {
+			repinsTotal.WithLabelValues("error").Inc()
+			logger.Error("Failed to re-pin missing CID", "cid", rec.CID, "filename", rec.Filename, "error", err)
+			continue
+		}
+		repinsTotal.WithLabelValues("success").Inc()
+		repinned++
+		logger.Warn("Re-pinned missing CID", "cid", rec.CID, "filename", rec.Filename)
+	}
+
+	logger.Info("Re-pin check complete", "checked", len(records), "repinned", repinned)
+}
+
+// pinnedCIDs returns every CID currently pinned in the server's Pinata
+// account, reading pinList a page at a time.
+func pinnedCIDs(ctx context.Context) (map[string]bool, error) {
+	pinned := make(map[string]bool)
+	for offset := 0; ; offset += maxPinListLimit {
+		pins, err := listPinataPins(ctx, url.Values{
+			"status":     {"pinned"},
+			"pageLimit":  {strconv.Itoa(maxPinListLimit)},
+			"pageOffset": {strconv.Itoa(offset)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range pins.Rows {
+			pinned[row.IpfsPinHash] = true
+		}
+		if len(pins.Rows) < maxPinListLimit || offset+len(pins.Rows) >= pins.Count {
+			return pinned, nil
+		}
+	}
+}
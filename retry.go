@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// defaultMaxRetryAfter caps how long a Retry-After from Pinata may delay the
+// next attempt.
+const defaultMaxRetryAfter = 30 * time.Second
+
+// maxRetryAfter is the configured cap on honored Retry-After delays.
+var maxRetryAfter = defaultMaxRetryAfter
+
+// pinataStatusError is returned when Pinata responds with a non-OK status.
+// RetryAfter is set when the response carried a usable Retry-After header.
+type pinataStatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *pinataStatusError) Error() string {
+	return fmt.Sprintf("pinata API returned non-OK status: %s", e.Status)
+}
+
+// retryableError wraps transport-level failures that are safe to retry.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// isRetryable reports whether an upload attempt that failed with err should
+// be retried. Only network errors, 5xx and 429 responses qualify.
+func isRetryable(err error) bool {
+	var statusErr *pinataStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr *retryableError
+	return errors.As(err, &netErr)
+}
+
+// backoffDelay returns the delay before retry number attempt (starting at 0),
+// doubling each time and applying jitter in the upper half of the window.
+func backoffDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryDelay returns how long to wait before retry number attempt after err.
+// A 429 with Retry-After is honored up to maxRetryAfter; anything else uses
+// backoffDelay.
+func retryDelay(attempt int, err error) time.Duration {
+	var statusErr *pinataStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests && statusErr.RetryAfter > 0 {
+		return min(statusErr.RetryAfter, maxRetryAfter)
+	}
+	return backoffDelay(attempt)
+}
+
+// parseRetryAfter reads a Retry-After header given either as delay seconds
+// or as an HTTP date. It returns 0 when the header is absent or unusable.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
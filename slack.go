@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSlackFailureThreshold = 5
+	defaultSlackAlertInterval    = 15 * time.Minute
+	// slackRecentErrors is how many of the latest error messages an alert
+	// quotes.
+	slackRecentErrors = 5
+)
+
+var (
+	slackWebhookURL string
+	// slackFailureThreshold is the number of consecutive failed batches
+	// that triggers an alert.
+	slackFailureThreshold = defaultSlackFailureThreshold
+	// slackAlertInterval is the least time between two alerts, so a long
+	// outage is reported periodically rather than on every batch.
+	slackAlertInterval = defaultSlackAlertInterval
+)
+
+// failureAlerts tracks consecutive failed upload batches for Slack alerts.
+var failureAlerts struct {
+	mu          sync.Mutex
+	consecutive int
+	failedFiles int
+	recent      []string
+	lastSent    time.Time
+}
+
+// recordBatchOutcome counts a completed upload batch towards the Slack
+// failure alert. A batch in which every file failed extends the run of
+// failures; any success ends it. It does nothing when SLACK_WEBHOOK_URL is
+// unset.
+func recordBatchOutcome(ctx context.Context, result BatchUploadResponse) {
+	if slackWebhookURL == "" {
+		return
+	}
+
+	failureAlerts.mu.Lock()
+	defer failureAlerts.mu.Unlock()
+
+	if len(result.SuccessfulUploads) > 0 || len(result.Errors) == 0 {
+		failureAlerts.consecutive = 0
+		failureAlerts.failedFiles = 0
+		failureAlerts.recent = nil
+		return
+	}
+
+	failureAlerts.consecutive++
+	failureAlerts.failedFiles += len(result.Errors)
+	for _, e := range result.Errors {
+		failureAlerts.recent = append(failureAlerts.recent, e.Message)
+	}
+	if n := len(failureAlerts.recent); n > slackRecentErrors {
+		failureAlerts.recent = failureAlerts.recent[n-slackRecentErrors:]
+	}
+
+	if failureAlerts.consecutive < slackFailureThreshold || time.Since(failureAlerts.lastSent) < slackAlertInterval {
+		return
+	}
+	failureAlerts.lastSent = time.Now()
+
+	var text strings.Builder
+	fmt.Fprintf(&text, ":rotating_light: %s: the last %d upload requests failed (%d files).", serviceName, failureAlerts.consecutive, failureAlerts.failedFiles)
+	text.WriteString(" Recent errors:")
+	for _, message := range failureAlerts.recent {
+		text.WriteString("\n• " + message)
+	}
+	postSlackMessage(ctx, text.String())
+}
+
+// postSlackMessage sends text to SLACK_WEBHOOK_URL in the background.
+// Delivery failures are only logged.
+func postSlackMessage(ctx context.Context, text string) {
+	log := loggerFrom(ctx)
+	go func() {
+		body, err := json.Marshal(map[string]string{"text": text})
+		if err != nil {
+			log.Error("Failed to encode Slack message", "error", err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackWebhookURL, bytes.NewReader(body))
+		if err != nil {
+			log.Error("Failed to create Slack request", "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Warn("Slack alert delivery failed", "error", withoutURL(err))
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			log.Warn("Slack returned non-success status", "status_code", resp.StatusCode)
+			return
+		}
+		log.Info("Sent Slack failure alert")
+	}()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackAlertsAfterConsecutiveFailures(t *testing.T) {
+	messages := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&body)
+		messages <- body.Text
+	}))
+	defer server.Close()
+
+	prevURL, prevThreshold, prevInterval := slackWebhookURL, slackFailureThreshold, slackAlertInterval
+	slackWebhookURL, slackFailureThreshold, slackAlertInterval = server.URL, 2, time.Hour
+	resetFailureAlerts := func() {
+		failureAlerts.mu.Lock()
+		defer failureAlerts.mu.Unlock()
+		failureAlerts.consecutive, failureAlerts.failedFiles, failureAlerts.recent, failureAlerts.lastSent = 0, 0, nil, time.Time{}
+	}
+	resetFailureAlerts()
+	t.Cleanup(func() {
+		slackWebhookURL, slackFailureThreshold, slackAlertInterval = prevURL, prevThreshold, prevInterval
+		resetFailureAlerts()
+	})
+
+	ctx := context.Background()
+	failed := func(message string) BatchUploadResponse {
+		return BatchUploadResponse{Errors: []FileError{{Filename: "a.txt", Code: CodeUploadFailed, Message: message}}}
+	}
+	succeeded := BatchUploadResponse{SuccessfulUploads: []UploadResponse{{}}}
+	alerted := func() bool {
+		failureAlerts.mu.Lock()
+		defer failureAlerts.mu.Unlock()
+		return !failureAlerts.lastSent.IsZero()
+	}
+
+	// A success in between restarts the count.
+	recordBatchOutcome(ctx, failed("first"))
+	recordBatchOutcome(ctx, succeeded)
+	recordBatchOutcome(ctx, failed("second"))
+	if alerted() {
+		t.Fatal("alerted before two consecutive failures")
+	}
+
+	recordBatchOutcome(ctx, failed("third"))
+	select {
+	case text := <-messages:
+		if !strings.Contains(text, "the last 2 upload requests failed (2 files)") || !strings.Contains(text, "• third") || strings.Contains(text, "first") {
+			t.Errorf("alert = %q, want the two failures since the success and their errors", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert after two consecutive failures")
+	}
+
+	// Further failures inside the alert interval are not reported again.
+	failureAlerts.mu.Lock()
+	sent := failureAlerts.lastSent
+	failureAlerts.mu.Unlock()
+	recordBatchOutcome(ctx, failed("fourth"))
+	failureAlerts.mu.Lock()
+	defer failureAlerts.mu.Unlock()
+	if !failureAlerts.lastSent.Equal(sent) {
+		t.Error("alerted again within the alert interval")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// startTime is when the process started, for reporting uptime.
+var startTime = time.Now()
+
+// activity holds in-memory totals since the process started, for /stats.
+var activity struct {
+	uploads     atomic.Int64
+	errors      atomic.Int64
+	bytesPinned atomic.Int64
+}
+
+// StatsResponse is the body returned by /stats. UploadQueueCapacity is
+// omitted when the /upload queue is unbounded.
+type StatsResponse struct {
+	TotalUploads        int64     `json:"total_uploads"`
+	TotalBytesPinned    int64     `json:"total_bytes_pinned"`
+	ErrorCount          int64     `json:"error_count"`
+	InFlightUploads     int64     `json:"in_flight_uploads"`
+	UploadQueueDepth    int64     `json:"upload_queue_depth"`
+	UploadQueueCapacity int       `json:"upload_queue_capacity,omitempty"`
+	StartedAt           time.Time `json:"started_at"`
+	UptimeSeconds       int64     `json:"uptime_seconds"`
+}
+
+// handleStats reports activity since the process started. The counters are
+// kept in memory only, so they reset on restart. It never contacts Pinata.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(StatsResponse{
+		TotalUploads:        activity.uploads.Load(),
+		TotalBytesPinned:    activity.bytesPinned.Load(),
+		ErrorCount:          activity.errors.Load(),
+		InFlightUploads:     inFlight.Load(),
+		UploadQueueDepth:    queuedUploads.Load(),
+		UploadQueueCapacity: uploadQueueDepth,
+		StartedAt:           startTime.UTC(),
+		UptimeSeconds:       int64(time.Since(startTime).Seconds()),
+	})
+}
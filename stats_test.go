@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getStats returns the current /stats body.
+func getStats(t *testing.T) StatsResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleStats(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var stats StatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestStatsCountsUploads(t *testing.T) {
+	useMockStorage(t)
+
+	before := getStats(t)
+	handleUpload(httptest.NewRecorder(), newUploadRequest(t,
+		testFile{"hello.txt", "hello"},
+		testFile{"empty.txt", ""},
+	))
+	after := getStats(t)
+
+	if got := after.TotalUploads - before.TotalUploads; got != 1 {
+		t.Errorf("total_uploads grew by %d, want 1", got)
+	}
+	if got := after.ErrorCount - before.ErrorCount; got != 1 {
+		t.Errorf("error_count grew by %d, want 1", got)
+	}
+	if got := after.TotalBytesPinned - before.TotalBytesPinned; got != int64(len("hello")) {
+		t.Errorf("total_bytes_pinned grew by %d, want %d", got, len("hello"))
+	}
+	if after.InFlightUploads != 0 || after.StartedAt.IsZero() {
+		t.Errorf("stats = %+v, want nothing in flight and a start time", after)
+	}
+}
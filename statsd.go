@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultStatsdPrefix = "fileupload."
+
+// statsd settings. The client is only created when statsdAddr is set.
+var (
+	statsdAddr   string
+	statsdPrefix = defaultStatsdPrefix
+	statsdTags   []string
+)
+
+// statsd mirrors the upload metrics to a statsd or DogStatsD server. It is
+// nil when STATSD_ADDR is unset, and every method is a no-op on a nil
+// client.
+var statsd *statsdClient
+
+// statsdClient sends metrics over UDP in the DogStatsD line format. Sends
+// are fire-and-forget so a missing collector never slows uploads.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+}
+
+// newStatsdClient returns a client sending to addr. Every metric name is
+// prefixed with prefix and carries tags, given as "key:value" strings.
+func newStatsdClient(addr, prefix string, tags []string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to statsd at %s: %w", addr, err)
+	}
+	return &statsdClient{conn: conn, prefix: prefix, tags: strings.Join(tags, ",")}, nil
+}
+
+// count adds delta to a counter.
+func (c *statsdClient) count(name string, delta int64, tags ...string) {
+	c.send(name, strconv.FormatInt(delta, 10), "c", tags)
+}
+
+// gauge sets a gauge to value.
+func (c *statsdClient) gauge(name string, value int64, tags ...string) {
+	c.send(name, strconv.FormatInt(value, 10), "g", tags)
+}
+
+// timing records a duration in milliseconds.
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// send writes a single metric line.
+func (c *statsdClient) send(name, value, kind string, tags []string) {
+	if c == nil {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if c.tags != "" || len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(c.tags)
+		for i, tag := range tags {
+			if i > 0 || c.tags != "" {
+				b.WriteByte(',')
+			}
+			b.WriteString(tag)
+		}
+	}
+	c.conn.Write([]byte(b.String()))
+}
+
+// Close releases the UDP socket.
+func (c *statsdClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// StorageProvider stores uploaded files and returns their content
+// identifiers.
+type StorageProvider interface {
+	// Upload stores the content read from r under filename.
+	Upload(ctx context.Context, filename string, r io.Reader) (UploadResult, error)
+}
+
+// UploadResult is the outcome of storing a single file. Duration covers the
+// whole exchange with the provider, including retries and reading the
+// response, and is filled in by uploadContent along with Encrypted. GroupID
+// is set by providers that assigned the file to a group. Provider names the
+// provider that stored the file. SecondaryCID and SecondaryErr report the
+// copy sent to the secondary provider, if any.
+type UploadResult struct {
+	Provider     string
+	CID          string
+	Size         int
+	Timestamp    string
+	Duration     time.Duration
+	Encrypted    bool
+	GroupID      string
+	SecondaryCID string
+	SecondaryErr error
+	// MetadataStripped is set when image metadata was removed before the
+	// upload.
+	MetadataStripped bool
+	// Cached is set when the result came from the dedup cache and nothing
+	// was uploaded.
+	Cached bool
+	// ContentSHA256 is the SHA-256 of the content sent to the provider,
+	// before any encryption, computed as it was streamed.
+	ContentSHA256 string
+}
+
+// defaultStorageProvider is used when STORAGE_PROVIDER is unset.
+const defaultStorageProvider = "pinata"
+
+// storageProvider names the provider selected by STORAGE_PROVIDER, and
+// storage is its implementation.
+var (
+	storageProvider                 = defaultStorageProvider
+	storage         StorageProvider = PinataProvider{}
+)
+
+// newStorageProvider returns the provider registered under name.
+func newStorageProvider(name string) (StorageProvider, error) {
+	switch name {
+	case "", "pinata":
+		return PinataProvider{Client: pinataClient}, nil
+	case "mock":
+		return MockProvider{
+			Latency:     envDuration("MOCK_LATENCY", 0),
+			FailureRate: envFloat("MOCK_FAILURE_RATE", 0),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage provider %q", name)
+	}
+}
+
+type uploadOptionsKey struct{}
+
+// withUploadOptions attaches per-request upload options to ctx so providers
+// can apply the ones they support.
+func withUploadOptions(ctx context.Context, opts uploadOptions) context.Context {
+	return context.WithValue(ctx, uploadOptionsKey{}, opts)
+}
+
+// uploadOptionsFrom returns the upload options stored in ctx, if any.
+func uploadOptionsFrom(ctx context.Context) uploadOptions {
+	opts, _ := ctx.Value(uploadOptionsKey{}).(uploadOptions)
+	return opts
+}
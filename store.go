@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// uploadStore persists an audit trail of successful uploads in SQLite.
+type uploadStore struct {
+	db *sql.DB
+}
+
+// store is nil when DB_PATH is unset, which disables persistence.
+var store *uploadStore
+
+// uploadRecord is a single row of the uploads table.
+type uploadRecord struct {
+	CID       string    `json:"cid"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	RequestID string    `json:"request_id"`
+	// Account identifies the Pinata account the CID was pinned with, as
+	// returned by pinataAccountKey. It is empty for the server's own
+	// credentials, which rows from before it was recorded are assumed to be.
+	Account string `json:"-"`
+	// ExpiresAt is when the CID should be unpinned, or zero to keep it.
+	ExpiresAt time.Time `json:"-"`
+}
+
+// uploadFilter selects a page of the uploads table.
+type uploadFilter struct {
+	// Filename matches records whose filename contains it.
+	Filename string
+	// From and To bound created_at inclusively when non-zero.
+	From, To time.Time
+	Oldest   bool
+	Limit    int
+	Offset   int
+}
+
+// openUploadStore opens the SQLite database at path and creates the uploads
+// table if it does not exist.
+func openUploadStore(path string) (*uploadStore, error) {
+	// The sqlite time format keeps timestamps sortable as text, and the busy
+	// timeout makes writers wait for a lock instead of failing.
+	db, err := sql.Open("sqlite", path+"?_time_format=sqlite&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	// Concurrent upload workers would otherwise contend for SQLite's single
+	// writer lock across separate connections.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS uploads (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		cid TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		request_id TEXT NOT NULL DEFAULT '',
+		account TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create uploads table: %w", err)
+	}
+	if err := addColumnIfMissing(db, "uploads", "account", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate uploads table: %w", err)
+	}
+
+	// CIDs deliberately unpinned through /unpin are remembered so that the
+	// re-pin job does not bring them back. Like uploads they are per Pinata
+	// account, since unpinning from one account leaves other accounts' pins.
+	if err := keyUnpinnedByAccount(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate unpinned table: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS unpinned (
+		account TEXT NOT NULL DEFAULT '',
+		cid TEXT NOT NULL,
+		unpinned_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (account, cid)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create unpinned table: %w", err)
+	}
+
+	// Expiry times are stored as Unix seconds so they compare numerically.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS expiries (
+		cid TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create expiries table: %w", err)
+	}
+
+	// Quota usage is keyed by a hash of the API key, never the key itself.
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS quota_usage (
+		key_hash TEXT NOT NULL,
+		period TEXT NOT NULL,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		pins INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (key_hash, period)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create quota_usage table: %w", err)
+	}
+
+	return &uploadStore{db: db}, nil
+}
+
+// tableColumns returns the names of table's columns, or none when the table
+// does not exist.
+func tableColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
+
+// addColumnIfMissing adds column to a table created before the column
+// existed.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil || slices.Contains(columns, column) {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// keyUnpinnedByAccount rebuilds an unpinned table keyed by cid alone, from
+// before unpins were recorded per account, as one keyed by account and cid.
+// Its rows were all unpinned with the server's own credentials.
+func keyUnpinnedByAccount(db *sql.DB) error {
+	columns, err := tableColumns(db, "unpinned")
+	if err != nil || len(columns) == 0 || slices.Contains(columns, "account") {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`ALTER TABLE unpinned RENAME TO unpinned_by_cid`,
+		`CREATE TABLE unpinned (
+			account TEXT NOT NULL DEFAULT '',
+			cid TEXT NOT NULL,
+			unpinned_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (account, cid)
+		)`,
+		`INSERT INTO unpinned (account, cid, unpinned_at) SELECT '', cid, unpinned_at FROM unpinned_by_cid`,
+		`DROP TABLE unpinned_by_cid`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// insert adds rec to the uploads table. Uploading a CID again clears any
+// earlier unpin. Expiries only track the server's own pins: a CID the server
+// already holds without a ttl stays pinned, an upload without a ttl cancels
+// any pending expiry, and the latest of several expiries wins.
+func (s *uploadStore) insert(ctx context.Context, rec uploadRecord) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var permanent bool
+	if rec.Account == "" && !rec.ExpiresAt.IsZero() {
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM uploads WHERE cid = ? AND account = '')
+			AND NOT EXISTS (SELECT 1 FROM unpinned WHERE account = '' AND cid = ?)
+			AND NOT EXISTS (SELECT 1 FROM expiries WHERE cid = ?)`,
+			rec.CID, rec.CID, rec.CID).Scan(&permanent)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO uploads (cid, filename, size, created_at, request_id, account) VALUES (?, ?, ?, ?, ?, ?)`,
+		rec.CID, rec.Filename, rec.Size, rec.CreatedAt.UTC(), rec.RequestID, rec.Account)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM unpinned WHERE account = ? AND cid = ?`, rec.Account, rec.CID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case rec.Account != "" || permanent:
+	case rec.ExpiresAt.IsZero():
+		_, err = tx.ExecContext(ctx, `DELETE FROM expiries WHERE cid = ?`, rec.CID)
+	default:
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO expiries (cid, expires_at) VALUES (?, ?)
+			ON CONFLICT (cid) DO UPDATE SET expires_at = MAX(expires_at, excluded.expires_at)`,
+			rec.CID, rec.ExpiresAt.Unix())
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// expired returns the CIDs whose expiry is at or before now.
+func (s *uploadStore) expired(ctx context.Context, now time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT cid FROM expiries WHERE expires_at <= ? ORDER BY expires_at`, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cids []string
+	for rows.Next() {
+		var cid string
+		if err := rows.Scan(&cid); err != nil {
+			return nil, err
+		}
+		cids = append(cids, cid)
+	}
+	return cids, rows.Err()
+}
+
+// markUnpinned records that cid was intentionally unpinned from account.
+// Unpinning from the server's own account also cancels any pending expiry.
+func (s *uploadStore) markUnpinned(ctx context.Context, account, cid string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO unpinned (account, cid, unpinned_at) VALUES (?, ?, ?)
+		ON CONFLICT (account, cid) DO UPDATE SET unpinned_at = excluded.unpinned_at`,
+		account, cid, time.Now().UTC())
+	if err != nil || account != "" {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `DELETE FROM expiries WHERE cid = ?`, cid)
+	return err
+}
+
+// pinnedUploads returns one record per CID that was uploaded with account
+// and has not since been unpinned, using the most recent filename for each.
+func (s *uploadStore) pinnedUploads(ctx context.Context, account string) ([]uploadRecord, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT cid, filename, size, created_at, request_id FROM uploads
+		WHERE id IN (SELECT MAX(id) FROM uploads WHERE account = ? GROUP BY cid)
+		AND cid NOT IN (SELECT cid FROM unpinned WHERE account = ?)
+		ORDER BY id`, account, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []uploadRecord
+	for rows.Next() {
+		var rec uploadRecord
+		if err := rows.Scan(&rec.CID, &rec.Filename, &rec.Size, &rec.CreatedAt, &rec.RequestID); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// listUploads returns the page of records matching f, newest first unless
+// f.Oldest is set, along with the total number of matches.
+func (s *uploadStore) listUploads(ctx context.Context, f uploadFilter) ([]uploadRecord, int, error) {
+	var where []string
+	var args []any
+	if f.Filename != "" {
+		where = append(where, `filename LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(f.Filename)+"%")
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at <= ?")
+		args = append(args, f.To.UTC())
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM uploads"+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := "DESC"
+	if f.Oldest {
+		order = "ASC"
+	}
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT cid, filename, size, created_at, request_id FROM uploads"+clause+" ORDER BY id "+order+" LIMIT ? OFFSET ?",
+		append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := make([]uploadRecord, 0, f.Limit)
+	for rows.Next() {
+		var rec uploadRecord
+		if err := rows.Scan(&rec.CID, &rec.Filename, &rec.Size, &rec.CreatedAt, &rec.RequestID); err != nil {
+			return nil, 0, err
+		}
+		records = append(records, rec)
+	}
+	return records, total, rows.Err()
+}
+
+// addUsage adds bytes and pins to the usage of keyHash in period.
+func (s *uploadStore) addUsage(ctx context.Context, keyHash, period string, bytes, pins int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO quota_usage (key_hash, period, bytes, pins) VALUES (?, ?, ?, ?)
+		ON CONFLICT (key_hash, period) DO UPDATE SET bytes = bytes + excluded.bytes, pins = pins + excluded.pins`,
+		keyHash, period, bytes, pins)
+	return err
+}
+
+// usage returns the bytes and pins used by keyHash in period.
+func (s *uploadStore) usage(ctx context.Context, keyHash, period string) (bytes, pins int64, err error) {
+	err = s.db.QueryRowContext(ctx,
+		`SELECT bytes, pins FROM quota_usage WHERE key_hash = ? AND period = ?`,
+		keyHash, period).Scan(&bytes, &pins)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	return bytes, pins, err
+}
+
+// likeEscaper escapes the LIKE wildcards in a literal substring.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Close releases the underlying database.
+func (s *uploadStore) Close() error {
+	return s.db.Close()
+}
+
+// recordUpload persists a successful upload when persistence is enabled.
+// Failures are logged and otherwise ignored so they never affect the
+// response to the client.
+func recordUpload(ctx context.Context, filename string, size int64, cid string) {
+	recordExpiringUpload(ctx, filename, size, cid, time.Time{})
+}
+
+// recordExpiringUpload is recordUpload for a CID that should be unpinned at
+// expiresAt. A zero expiresAt keeps the CID pinned indefinitely.
+func recordExpiringUpload(ctx context.Context, filename string, size int64, cid string, expiresAt time.Time) {
+	if store == nil {
+		return
+	}
+
+	err := store.insert(context.WithoutCancel(ctx), uploadRecord{
+		CID:       cid,
+		Filename:  filename,
+		Size:      size,
+		CreatedAt: time.Now(),
+		RequestID: requestIDFrom(ctx),
+		Account:   pinataAccountKey(ctx),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to record upload", "filename", filename, "cid", cid, "error", err)
+	}
+}
+
+// recordUnpin persists an intentional unpin from the caller's Pinata account
+// when persistence is enabled and drops cid from the dedup cache. Like
+// recordUpload, failures are only logged.
+func recordUnpin(ctx context.Context, cid string) {
+	dedupCache.forget(cid)
+	if store == nil {
+		return
+	}
+
+	if err := store.markUnpinned(context.WithoutCancel(ctx), pinataAccountKey(ctx), cid); err != nil {
+		loggerFrom(ctx).Error("Failed to record unpin", "cid", cid, "error", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUploadStoreExpiries(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	past, later := now.Add(-time.Hour), now.Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		uploads []uploadRecord
+		expired []string
+	}{
+		{
+			name:    "ttl upload expires",
+			uploads: []uploadRecord{{CID: "QmA", ExpiresAt: past}},
+			expired: []string{"QmA"},
+		},
+		{
+			name:    "ttl does not expire content already pinned without one",
+			uploads: []uploadRecord{{CID: "QmA"}, {CID: "QmA", ExpiresAt: past}},
+		},
+		{
+			name:    "upload without ttl cancels the expiry",
+			uploads: []uploadRecord{{CID: "QmA", ExpiresAt: past}, {CID: "QmA"}},
+		},
+		{
+			name:    "latest expiry wins",
+			uploads: []uploadRecord{{CID: "QmA", ExpiresAt: past}, {CID: "QmA", ExpiresAt: now.Add(time.Hour)}, {CID: "QmA", ExpiresAt: later}},
+		},
+		{
+			name:    "another account's upload leaves the expiry alone",
+			uploads: []uploadRecord{{CID: "QmA", ExpiresAt: past}, {CID: "QmA", Account: "tenant"}},
+			expired: []string{"QmA"},
+		},
+		{
+			name:    "another account's upload does not make the content permanent",
+			uploads: []uploadRecord{{CID: "QmA", Account: "tenant"}, {CID: "QmA", ExpiresAt: past}},
+			expired: []string{"QmA"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := openUploadStore(filepath.Join(t.TempDir(), "uploads.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ctx := context.Background()
+			for _, rec := range tt.uploads {
+				rec.CreatedAt = now
+				if err := s.insert(ctx, rec); err != nil {
+					t.Fatalf("insert %+v: %v", rec, err)
+				}
+			}
+			got, err := s.expired(ctx, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.expired) {
+				t.Errorf("expired = %v, want %v", got, tt.expired)
+			}
+		})
+	}
+}
+
+func TestUploadStoreExpiryAfterUnpin(t *testing.T) {
+	s, err := openUploadStore(filepath.Join(t.TempDir(), "uploads.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Once unpinned, content uploaded again with a ttl expires even though an
+	// earlier upload had none.
+	ctx := context.Background()
+	now := time.Now()
+	if err := s.insert(ctx, uploadRecord{CID: "QmA", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.markUnpinned(ctx, "", "QmA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.insert(ctx, uploadRecord{CID: "QmA", CreatedAt: now, ExpiresAt: now.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.expired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"QmA"}) {
+		t.Errorf("expired = %v, want [QmA]", got)
+	}
+}
+
+func TestUploadStoreUnpinsArePerAccount(t *testing.T) {
+	s, err := openUploadStore(filepath.Join(t.TempDir(), "uploads.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	for _, rec := range []uploadRecord{
+		{CID: "QmA", ExpiresAt: now.Add(-time.Minute)},
+		{CID: "QmA", Account: "tenant"},
+		{CID: "QmB"},
+	} {
+		rec.CreatedAt = now
+		if err := s.insert(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A tenant unpinning its copy leaves the server's pin and its expiry.
+	if err := s.markUnpinned(ctx, "tenant", "QmA"); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, s, "", "QmA", "QmB")
+	assertPinned(t, s, "tenant")
+	if got, _ := s.expired(ctx, now); !slices.Equal(got, []string{"QmA"}) {
+		t.Errorf("expired = %v, want [QmA]", got)
+	}
+
+	// A tenant uploading again does not undo the server's unpin.
+	if err := s.markUnpinned(ctx, "", "QmB"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.insert(ctx, uploadRecord{CID: "QmB", Account: "tenant", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, s, "", "QmA")
+	assertPinned(t, s, "tenant", "QmB")
+}
+
+func TestUploadStoreMigratesUnpinned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploads.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE unpinned (cid TEXT PRIMARY KEY, unpinned_at TIMESTAMP NOT NULL)`,
+		`INSERT INTO unpinned (cid, unpinned_at) VALUES ('QmA', '2024-01-01 00:00:00')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	s, err := openUploadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// The old unpin belongs to the server's account, so it hides the
+	// server's upload from before it but not a tenant's.
+	ctx := context.Background()
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO uploads (cid, filename, size, created_at) VALUES ('QmA', 'a', 1, ?)`, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.insert(ctx, uploadRecord{CID: "QmA", Account: "tenant", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, s, "")
+	assertPinned(t, s, "tenant", "QmA")
+}
+
+// assertPinned checks the CIDs pinnedUploads returns for account.
+func assertPinned(t *testing.T, s *uploadStore, account string, want ...string) {
+	t.Helper()
+	records, err := s.pinnedUploads(context.Background(), account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range records {
+		got = append(got, rec.CID)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("pinned for account %q = %v, want %v", account, got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// uploadTempDir is where multipart bodies that exceed the in-memory limit are
+// spooled. Empty means the system temp directory.
+var uploadTempDir string
+
+// spillDiskFull is set when spooling a request fails for lack of space, and
+// cleared by checkSpillSpace once there is room for a full request again.
+var spillDiskFull atomic.Bool
+
+// diskFreeSpace reports the space available in a directory. Tests replace it
+// to simulate a full disk.
+var diskFreeSpace = freeDiskSpace
+
+// isDiskFull reports whether err means the filesystem is out of space.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// checkSpillSpace reports an error while the temp directory has run out of
+// space and has not yet recovered enough for a maximum-size request.
+func checkSpillSpace() error {
+	if !spillDiskFull.Load() {
+		return nil
+	}
+
+	dir := os.TempDir()
+	free, err := diskFreeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check free space in %s: %w", dir, err)
+	}
+	if free >= 0 && free < maxRequestBytes {
+		return fmt.Errorf("%s has %d bytes free, less than MAX_REQUEST_BYTES (%d)", dir, free, maxRequestBytes)
+	}
+	spillDiskFull.Store(false)
+	return nil
+}
+
+// prepareUploadTempDir creates dir if needed, checks that it is writable and
+// has room for at least one maximum-size request, and makes it the temp
+// directory used for multipart spillover.
+func prepareUploadTempDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	probe, err := os.CreateTemp(dir, "probe-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	free, err := diskFreeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check free space in %s: %w", dir, err)
+	}
+	if free >= 0 && free < maxRequestBytes {
+		return fmt.Errorf("%s has %d bytes free, less than MAX_REQUEST_BYTES (%d)", dir, free, maxRequestBytes)
+	}
+
+	// mime/multipart creates its spill files in os.TempDir, which honors
+	// TMPDIR on Unix and TMP on Windows.
+	os.Setenv("TMPDIR", dir)
+	os.Setenv("TMP", dir)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fullDiskReader yields the first n bytes of a multipart body and then fails
+// the way a write to a full temp directory does while the body is spooled.
+type fullDiskReader struct {
+	r io.Reader
+	n int64
+}
+
+func (f *fullDiskReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, &os.PathError{Op: "write", Path: os.TempDir() + "/multipart-1", Err: syscall.ENOSPC}
+	}
+	n, err := io.LimitReader(f.r, f.n).Read(p)
+	f.n -= int64(n)
+	return n, err
+}
+
+// setDiskFreeSpace makes the free-space probe report free bytes for the rest
+// of the test.
+func setDiskFreeSpace(t *testing.T, free int64) {
+	t.Helper()
+	prev := diskFreeSpace
+	diskFreeSpace = func(string) (int64, error) { return free, nil }
+	t.Cleanup(func() {
+		diskFreeSpace = prev
+		spillDiskFull.Store(false)
+	})
+}
+
+func TestUploadReportsFullDisk(t *testing.T) {
+	useMockStorage(t)
+	setDiskFreeSpace(t, 0)
+	prevLogger := logger
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	t.Cleanup(func() { logger = prevLogger })
+
+	req := newUploadRequest(t, testFile{"big.bin", strings.Repeat("x", 1024)})
+	req.Body = io.NopCloser(&fullDiskReader{r: req.Body, n: 512})
+	rec := httptest.NewRecorder()
+	handleUpload(rec, req)
+
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusInsufficientStorage, rec.Body)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != CodeInsufficientStorage {
+		t.Errorf("code = %s, want %s", body.Code, CodeInsufficientStorage)
+	}
+
+	// The node reports itself unready until space is freed.
+	rec = httptest.NewRecorder()
+	handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), string(CodeInsufficientStorage)) {
+		t.Errorf("/ready with a full disk = %d %s, want 503 INSUFFICIENT_STORAGE", rec.Code, rec.Body)
+	}
+
+	diskFreeSpace = func(string) (int64, error) { return maxRequestBytes, nil }
+	rec = httptest.NewRecorder()
+	handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/ready after space was freed = %d %s, want 200", rec.Code, rec.Body)
+	}
+}
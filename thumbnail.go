@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	_ "image/gif"
+)
+
+// maxThumbnailSourcePixels bounds the size of images decoded for
+// thumbnails.
+const maxThumbnailSourcePixels = 40_000_000
+
+// thumbnailSize is the largest width or height of generated thumbnails.
+// Thumbnails are only generated when it is set.
+var thumbnailSize int
+
+// addFileThumbnail pins a thumbnail of fh when it is an image, recording the
+// result on upload. Failures never fail the upload itself; they are noted in
+// the response instead.
+func addFileThumbnail(ctx context.Context, upload *UploadResponse, fh *multipart.FileHeader, opts uploadOptions) {
+	if thumbnailSize == 0 {
+		return
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		upload.ThumbnailError = fmt.Sprintf("could not read image: %v", err)
+		return
+	}
+	defer file.Close()
+
+	addThumbnail(ctx, upload, fh.Filename, file, opts)
+}
+
+// addThumbnail is addFileThumbnail for content read from r.
+func addThumbnail(ctx context.Context, upload *UploadResponse, filename string, r io.ReadSeeker, opts uploadOptions) {
+	if thumbnailSize == 0 {
+		return
+	}
+
+	// Only the leading bytes are needed to tell whether this is an image,
+	// so other files are never read in full.
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(r, head)
+	if !strings.HasPrefix(http.DetectContentType(head[:n]), "image/") {
+		return
+	}
+
+	data, name, err := makeThumbnail(r, filename)
+	if err != nil {
+		loggerFrom(ctx).Info("Skipping thumbnail", "filename", filename, "error", err)
+		upload.ThumbnailError = err.Error()
+		return
+	}
+
+	if opts.Metadata != nil && opts.Metadata.Name != "" {
+		opts = opts.withName(opts.Metadata.Name + "-thumbnail")
+	}
+	result, cached, err := uploadDeduped(ctx, bytesSHA256(data), opts, func() (UploadResult, error) {
+		return uploadContent(ctx, name, bytes.NewReader(data), opts)
+	})
+	if err != nil {
+		loggerFrom(ctx).Warn("Thumbnail upload failed", "filename", name, "error", err)
+		upload.ThumbnailError = fmt.Sprintf("thumbnail upload failed: %v", err)
+		return
+	}
+	loggerFrom(ctx).Info("Thumbnail uploaded", "filename", name, "size", len(data), "cid", result.CID)
+	recordExpiringUpload(ctx, name, int64(len(data)), result.CID, opts.ExpiresAt)
+	if !cached {
+		chargeQuota(ctx, int64(len(data)))
+	}
+	upload.ThumbnailHash = result.CID
+}
+
+// makeThumbnail decodes the image read from r and encodes a copy no larger
+// than thumbnailSize in either dimension, returning it with its filename.
+// PNG and GIF sources produce a PNG to keep transparency; others a JPEG.
+func makeThumbnail(r io.ReadSeeker, filename string) ([]byte, string, error) {
+	// The dimensions are checked from the header before decoding, since a
+	// small compressed file can expand to gigabytes of pixels.
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", fmt.Errorf("could not read image: %v", err)
+	}
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not decode image: %v", err)
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return nil, "", fmt.Errorf("image is %dx%d, too large for a thumbnail", config.Width, config.Height)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", fmt.Errorf("could not read image: %v", err)
+	}
+	src, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not decode image: %v", err)
+	}
+	thumb := resizeImage(src, thumbnailSize)
+
+	var buf bytes.Buffer
+	name := strings.TrimSuffix(filename, filepath.Ext(filename)) + "-thumbnail"
+	if format == "png" || format == "gif" {
+		err = png.Encode(&buf, thumb)
+		name += ".png"
+	} else {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		name += ".jpg"
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("could not encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), name, nil
+}
+
+// resizeImage scales src down so that neither side exceeds maxSize,
+// averaging the source pixels covered by each thumbnail pixel. Images that
+// already fit are copied unscaled.
+func resizeImage(src image.Image, maxSize int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxSize || h > maxSize {
+		if w >= h {
+			w, h = maxSize, max(1, h*maxSize/b.Dx())
+		} else {
+			w, h = max(1, w*maxSize/b.Dy()), maxSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultPinataMaxIdleConns        = 100
+	defaultPinataMaxIdleConnsPerHost = 32
+	defaultPinataMaxConnsPerHost     = 64
+	defaultPinataIdleConnTimeout     = 90 * time.Second
+)
+
+// Connection pool settings for pinataClient. Every Pinata call goes to the
+// same host, so the per-host idle limit is what allows a batch's concurrent
+// uploads to reuse connections; the default transport keeps only two.
+var (
+	pinataMaxIdleConns        = defaultPinataMaxIdleConns
+	pinataMaxIdleConnsPerHost = defaultPinataMaxIdleConnsPerHost
+	pinataMaxConnsPerHost     = defaultPinataMaxConnsPerHost
+	pinataIdleConnTimeout     = defaultPinataIdleConnTimeout
+)
+
+// pinataProxyURL overrides the proxy taken from HTTPS_PROXY, HTTP_PROXY and
+// NO_PROXY for Pinata requests when set.
+var pinataProxyURL *url.URL
+
+// pinataClient is shared by all Pinata requests so connections are pooled
+// across uploads. It is rebuilt by loadConfig; overall request deadlines
+// come from the request contexts.
+var pinataClient = newPinataClient()
+
+// newPinataClient returns a client whose transport uses the current pool
+// settings.
+func newPinataClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: pinataProxy,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          pinataMaxIdleConns,
+			MaxIdleConnsPerHost:   pinataMaxIdleConnsPerHost,
+			MaxConnsPerHost:       pinataMaxConnsPerHost,
+			IdleConnTimeout:       pinataIdleConnTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// pinataProxy selects the proxy for a Pinata request.
+func pinataProxy(req *http.Request) (*url.URL, error) {
+	if pinataProxyURL != nil {
+		return pinataProxyURL, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// parseProxyURL validates a proxy URL given in configuration.
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, must be http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+// effectivePinataProxy returns the host of the proxy used to reach
+// endpoint, or "" when requests go direct. Credentials in the proxy URL are
+// never included.
+func effectivePinataProxy(endpoint string) string {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return ""
+	}
+	proxy, err := pinataProxy(req)
+	if err != nil || proxy == nil {
+		return ""
+	}
+	return proxy.Host
+}
+
+// drainAndClose reads what is left of a response body before closing it,
+// since the transport only reuses connections whose body was read to EOF.
+// Bodies larger than a few KB are not worth reading just to save a
+// connection.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, 4<<10))
+	body.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkPinataUploadBatch uploads batches of small files concurrently to
+// a local TLS server, comparing the default transport with the pooled one
+// newPinataClient builds. conns/batch counts the connections each batch had
+// to open.
+func BenchmarkPinataUploadBatch(b *testing.B) {
+	transports := []struct {
+		name string
+		new  func() *http.Transport
+	}{
+		{"default", func() *http.Transport { return http.DefaultTransport.(*http.Transport).Clone() }},
+		{"pooled", func() *http.Transport { return newPinataClient().Transport.(*http.Transport) }},
+	}
+	for _, tt := range transports {
+		b.Run(tt.name, func(b *testing.B) {
+			benchmarkUploadBatch(b, tt.new())
+		})
+	}
+}
+
+func benchmarkUploadBatch(b *testing.B, transport *http.Transport) {
+	const (
+		batchSize = 16
+		fileSize  = 4 << 10
+	)
+
+	var newConns atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, pinataOK)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	b.Setenv("PINATA_API_URL", server.URL)
+	transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	defer transport.CloseIdleConnections()
+	provider := PinataProvider{Client: &http.Client{Transport: transport}}
+	content := bytes.Repeat([]byte("x"), fileSize)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for range batchSize {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := provider.Upload(context.Background(), "bench.bin", bytes.NewReader(content)); err != nil {
+					b.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(newConns.Load())/float64(b.N), "conns/batch")
+}
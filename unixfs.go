@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// The defaults used by IPFS, and by Pinata, when adding a file: fixed-size
+// 256 KiB chunks arranged in a balanced DAG of at most 174 links per node.
+const (
+	unixfsChunkSize = 256 << 10
+	unixfsMaxLinks  = 174
+)
+
+// verifyCID enables recomputing the CID of each upload locally and
+// rejecting Pinata responses that do not match. It hashes every byte again,
+// so it is off by default.
+var verifyCID bool
+
+// unixfsLink is a node of the DAG as seen from its parent.
+type unixfsLink struct {
+	cid      []byte
+	tsize    uint64 // serialized size of the node and everything below it
+	filesize uint64 // file bytes stored below the node
+}
+
+// unixfsBuilder computes the CID IPFS assigns to a file, with the default
+// chunker and balanced layout, from the bytes written to it. CIDv0 uses
+// dag-pb leaves; CIDv1 uses raw leaves, as "ipfs add --cid-version=1"
+// does. Only the leaf hashes are kept in memory.
+type unixfsBuilder struct {
+	v1     bool
+	chunk  []byte
+	leaves []unixfsLink
+}
+
+// newUnixFSBuilder returns a builder for the given CID version.
+func newUnixFSBuilder(cidVersion int) *unixfsBuilder {
+	return &unixfsBuilder{v1: cidVersion == 1, chunk: make([]byte, 0, unixfsChunkSize)}
+}
+
+// Write buffers p, emitting a leaf for every complete chunk.
+func (b *unixfsBuilder) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(unixfsChunkSize-len(b.chunk), len(p))
+		b.chunk = append(b.chunk, p[:take]...)
+		p = p[take:]
+		if len(b.chunk) == unixfsChunkSize {
+			b.addLeaf()
+		}
+	}
+	return n, nil
+}
+
+// addLeaf turns the buffered chunk into a leaf.
+func (b *unixfsBuilder) addLeaf() {
+	size := uint64(len(b.chunk))
+	if b.v1 {
+		b.leaves = append(b.leaves, unixfsLink{cid: cidBytes(cid.Raw, b.chunk, true), tsize: size, filesize: size})
+	} else {
+		node := encodeDagPB(nil, encodeUnixFSFile(b.chunk, size, nil))
+		b.leaves = append(b.leaves, unixfsLink{cid: cidBytes(cid.DagProtobuf, node, false), tsize: uint64(len(node)), filesize: size})
+	}
+	b.chunk = b.chunk[:0]
+}
+
+// CID returns the CID of everything written so far.
+func (b *unixfsBuilder) CID() (string, error) {
+	if len(b.chunk) > 0 || len(b.leaves) == 0 {
+		b.addLeaf()
+	}
+
+	root := b.leaves[0]
+	next := 1
+	for depth := 1; next < len(b.leaves); depth++ {
+		root, next = b.fill([]unixfsLink{root}, depth, next)
+	}
+
+	c, err := cid.Cast(root.cid)
+	if err != nil {
+		return "", fmt.Errorf("failed to build CID: %w", err)
+	}
+	return c.String(), nil
+}
+
+// fill adds subtrees of the given depth to children, starting at leaf
+// next, until the node is full or the leaves run out, mirroring the
+// balanced layout of the IPFS importer.
+func (b *unixfsBuilder) fill(children []unixfsLink, depth, next int) (unixfsLink, int) {
+	for len(children) < unixfsMaxLinks && next < len(b.leaves) {
+		if depth == 1 {
+			children = append(children, b.leaves[next])
+			next++
+			continue
+		}
+		var child unixfsLink
+		child, next = b.fill(nil, depth-1, next)
+		children = append(children, child)
+	}
+
+	var filesize, tsize uint64
+	blocksizes := make([]uint64, len(children))
+	for i, child := range children {
+		filesize += child.filesize
+		tsize += child.tsize
+		blocksizes[i] = child.filesize
+	}
+
+	node := encodeDagPB(children, encodeUnixFSFile(nil, filesize, blocksizes))
+	return unixfsLink{
+		cid:      cidBytes(cid.DagProtobuf, node, b.v1),
+		tsize:    tsize + uint64(len(node)),
+		filesize: filesize,
+	}, next
+}
+
+// cidBytes returns the binary CID of a block with the given codec, hashed
+// with SHA-256. CIDv0 is the bare multihash.
+func cidBytes(codec uint64, block []byte, v1 bool) []byte {
+	digest := sha256.Sum256(block)
+	mh := append([]byte{0x12, 0x20}, digest[:]...)
+	if !v1 {
+		return mh
+	}
+	prefix := binary.AppendUvarint([]byte{0x01}, codec)
+	return append(prefix, mh...)
+}
+
+// encodeUnixFSFile encodes the UnixFS Data message of a file node.
+func encodeUnixFSFile(data []byte, filesize uint64, blocksizes []uint64) []byte {
+	buf := appendVarintField(nil, 1, 2) // Type: File
+	if len(data) > 0 {
+		buf = appendBytesField(buf, 2, data)
+	}
+	buf = appendVarintField(buf, 3, filesize)
+	for _, size := range blocksizes {
+		buf = appendVarintField(buf, 4, size)
+	}
+	return buf
+}
+
+// encodeDagPB encodes a dag-pb node. Links precede the data, as the
+// canonical encoding requires, and carry an empty name.
+func encodeDagPB(links []unixfsLink, data []byte) []byte {
+	var buf []byte
+	for _, link := range links {
+		encoded := appendBytesField(nil, 1, link.cid)
+		encoded = appendBytesField(encoded, 2, nil)
+		encoded = appendVarintField(encoded, 3, link.tsize)
+		buf = appendBytesField(buf, 2, encoded)
+	}
+	return appendBytesField(buf, 1, data)
+}
+
+// appendVarintField appends a protobuf varint field.
+func appendVarintField(buf []byte, field int, value uint64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3)
+	return binary.AppendUvarint(buf, value)
+}
+
+// appendBytesField appends a protobuf length-delimited field.
+func appendBytesField(buf []byte, field int, value []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(field)<<3|2)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
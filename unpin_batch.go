@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxBatchUnpins bounds the number of CIDs unpinned by one request.
+const maxBatchUnpins = 100
+
+// UnpinBatchResult is the outcome of unpinning one CID.
+type UnpinBatchResult struct {
+	CID    string    `json:"cid"`
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Code   ErrorCode `json:"code,omitempty"`
+}
+
+// UnpinBatchResponse is the body returned by /unpin-batch, with one result
+// per distinct CID in request order.
+type UnpinBatchResponse struct {
+	Results         []UnpinBatchResult `json:"results"`
+	SuccessfulCount int                `json:"successful_count"`
+	FailedCount     int                `json:"failed_count"`
+}
+
+// handleUnpinBatch unpins a JSON array of CIDs, responding 207 when only
+// some of them could be unpinned. Repeated CIDs are unpinned once.
+func handleUnpinBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cids []string
+	if err := json.NewDecoder(r.Body).Decode(&cids); err != nil {
+		sendErrorResponse(w, "Invalid JSON body: expected an array of CIDs: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[string]bool, len(cids))
+	unique := cids[:0]
+	for _, cid := range cids {
+		cid = strings.TrimSpace(cid)
+		if seen[cid] {
+			continue
+		}
+		seen[cid] = true
+		unique = append(unique, cid)
+	}
+	if len(unique) == 0 {
+		sendErrorResponse(w, "No CIDs were given", http.StatusBadRequest)
+		return
+	}
+	if len(unique) > maxBatchUnpins {
+		sendErrorResponse(w, fmt.Sprintf("Too many CIDs: received %d, the limit is %d per request", len(unique), maxBatchUnpins), http.StatusBadRequest)
+		return
+	}
+
+	response := UnpinBatchResponse{Results: make([]UnpinBatchResult, len(unique))}
+
+	// Unpins run with the same concurrency limit as uploads.
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, uploadConcurrency)
+	for i, cid := range unique {
+		if !isPlausibleCID(cid) {
+			response.Results[i] = UnpinBatchResult{CID: cid, Error: fmt.Sprintf("Invalid cid: expected an alphanumeric CID of %d to %d characters", minCIDLength, maxCIDLength), Code: CodeBadRequest}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, cid string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := unpinCID(r.Context(), cid)
+			switch {
+			case errors.Is(err, errNotPinned):
+				response.Results[i] = UnpinBatchResult{CID: cid, Error: fmt.Sprintf("CID %s is not pinned", cid), Code: CodeNotFound}
+			case err != nil:
+				code := CodeUpstreamError
+				if uploadErrorCode(err) == CodePinataUnavailable {
+					code = CodePinataUnavailable
+				}
+				response.Results[i] = UnpinBatchResult{CID: cid, Error: fmt.Sprintf("Error unpinning %s: %v", cid, err), Code: code}
+			default:
+				response.Results[i] = UnpinBatchResult{CID: cid, Status: "unpinned"}
+			}
+		}(i, cid)
+	}
+	wg.Wait()
+
+	for _, result := range response.Results {
+		if result.Error != "" {
+			response.FailedCount++
+		} else {
+			response.SuccessfulCount++
+		}
+	}
+	loggerFrom(r.Context()).Info("Batch unpin finished", "unpinned", response.SuccessfulCount, "failed", response.FailedCount)
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.FailedCount > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnpinBatchReportsEachCID(t *testing.T) {
+	setPinataTestConfig(t, 0, time.Minute)
+	const (
+		pinned  = "QmPinnedPinnedPinnedPinnedPinnedPinned01"
+		missing = "QmMissingMissingMissingMissingMissing001"
+		broken  = "QmBrokenBrokenBrokenBrokenBrokenBroken01"
+	)
+	prevClient := pinataClient
+	var unpins []string
+	pinataClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		cid := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+		unpins = append(unpins, cid)
+		switch cid {
+		case missing:
+			return replyStatus(http.StatusNotFound)(req)
+		case broken:
+			return replyStatus(http.StatusServiceUnavailable)(req)
+		default:
+			return pinataReply(http.StatusOK, "OK"), nil
+		}
+	})}
+	prevConcurrency := uploadConcurrency
+	uploadConcurrency = 1
+	t.Cleanup(func() { pinataClient, uploadConcurrency = prevClient, prevConcurrency })
+
+	body := `["` + pinned + `", "` + missing + `", "bad!", "` + broken + `", "` + pinned + `"]`
+	rec := httptest.NewRecorder()
+	handleUnpinBatch(rec, httptest.NewRequest(http.MethodPost, "/unpin-batch", strings.NewReader(body)))
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, http.StatusMultiStatus, rec.Body)
+	}
+	var response UnpinBatchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		cid    string
+		status string
+		code   ErrorCode
+	}{
+		{pinned, "unpinned", ""},
+		{missing, "", CodeNotFound},
+		{"bad!", "", CodeBadRequest},
+		{broken, "", CodePinataUnavailable},
+	}
+	if len(response.Results) != len(want) {
+		t.Fatalf("results = %+v, want one per distinct CID", response.Results)
+	}
+	for i, w := range want {
+		got := response.Results[i]
+		if got.CID != w.cid || got.Status != w.status || got.Code != w.code {
+			t.Errorf("result %d = %+v, want cid %s, status %q, code %q", i, got, w.cid, w.status, w.code)
+		}
+	}
+	if response.SuccessfulCount != 1 || response.FailedCount != 3 {
+		t.Errorf("counts = %d succeeded, %d failed, want 1 and 3", response.SuccessfulCount, response.FailedCount)
+	}
+	if len(unpins) != 3 {
+		t.Errorf("Pinata unpinned %v, want each valid CID once", unpins)
+	}
+}
+
+func TestUnpinBatchRejectsTooManyCIDs(t *testing.T) {
+	cids := make([]string, maxBatchUnpins+1)
+	for i := range cids {
+		cids[i] = strings.Repeat("a", minCIDLength) + strings.Repeat("b", i)
+	}
+	body, _ := json.Marshal(cids)
+
+	rec := httptest.NewRecorder()
+	handleUnpinBatch(rec, httptest.NewRequest(http.MethodPost, "/unpin-batch", strings.NewReader(string(body))))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// base64UploadOverhead is the allowance for JSON framing on top of the
+// encoded file content.
+const base64UploadOverhead = 64 << 10
+
+// Base64UploadRequest is the body accepted by /upload-base64.
+type Base64UploadRequest struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+}
+
+// handleUploadBase64 pins a single file supplied as base64 inside a JSON
+// body, for clients that cannot easily build multipart requests.
+func handleUploadBase64(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(base64.StdEncoding.EncodedLen(int(maxPerFileSize)))+base64UploadOverhead)
+
+	var request Base64UploadRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendErrorResponse(w, fmt.Sprintf("Request body exceeds limit of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		sendErrorResponse(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request.Filename = strings.TrimSpace(request.Filename)
+	if request.Filename == "" {
+		sendErrorResponse(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(request.Content)
+	if err != nil {
+		sendErrorResponse(w, "Invalid base64 content: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pinBytesAndRespond(w, r, request.Filename, data)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// handleUploadRaw pins the request body as a single file named by the
+// X-Filename header, for clients that can only POST raw bytes.
+func handleUploadRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := strings.TrimSpace(r.Header.Get("X-Filename"))
+	if filename == "" {
+		sendErrorResponse(w, "Missing X-Filename header", http.StatusBadRequest)
+		return
+	}
+	if err := validateExtension(filename); err != nil {
+		sendCodedError(w, errorCode(err, CodeBlockedExtension), err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A declared length over the limit is rejected before reading; otherwise
+	// the limit is enforced as the body streams in.
+	if r.ContentLength > maxPerFileSize {
+		sendErrorResponse(w, fmt.Sprintf("Request body exceeds limit of %d bytes", maxPerFileSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPerFileSize)
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendErrorResponse(w, fmt.Sprintf("Request body exceeds limit of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		sendErrorResponse(w, "Error reading request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pinBytesAndRespond(w, r, filename, data)
+}
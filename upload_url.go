@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultURLFetchTimeout = 60 * time.Second
+	maxURLFetchRedirects   = 5
+)
+
+var (
+	urlFetchTimeout   = defaultURLFetchTimeout
+	allowPrivateFetch = false
+)
+
+// errPrivateAddress is returned when a remote URL resolves to an address
+// that is not publicly routable.
+var errPrivateAddress = errors.New("destination address is not allowed")
+
+// URLUploadRequest is the body accepted by /upload-url.
+type URLUploadRequest struct {
+	URL string `json:"url"`
+}
+
+// fetchClient downloads remote files. Its dialer checks every resolved
+// address, so redirects and DNS rebinding cannot reach private networks.
+// It never uses HTTP_PROXY or HTTPS_PROXY: through a proxy the dialer would
+// only see the proxy's address, not the destination's.
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				if allowPrivateFetch {
+					return nil
+				}
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return fmt.Errorf("%w: %s", errPrivateAddress, host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxURLFetchRedirects {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// handleUploadURL downloads a file from a public http(s) URL and pins it.
+func handleUploadURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request URLUploadRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		sendErrorResponse(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	remote, err := url.Parse(request.URL)
+	if err != nil || remote.Host == "" {
+		sendErrorResponse(w, "Invalid url", http.StatusBadRequest)
+		return
+	}
+	if remote.Scheme != "http" && remote.Scheme != "https" {
+		sendErrorResponse(w, "Invalid url: only http and https are supported", http.StatusBadRequest)
+		return
+	}
+	// The name is known before anything is downloaded, so a blocked file is
+	// never fetched.
+	if err := validateExtension(filenameFromURL(remote)); err != nil {
+		sendCodedError(w, errorCode(err, CodeBlockedExtension), err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := fetchRemoteFile(r.Context(), remote.String())
+	if err != nil {
+		var statusErr *remoteStatusError
+		switch {
+		case errors.As(err, &statusErr):
+			sendErrorResponse(w, fmt.Sprintf("Remote server returned %s", statusErr.Status), http.StatusBadGateway)
+		case errors.Is(err, errRemoteTooLarge):
+			sendErrorResponse(w, fmt.Sprintf("Remote file exceeds per-file limit of %d bytes", maxPerFileSize), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, errPrivateAddress):
+			sendErrorResponse(w, "Invalid url: destination address is not allowed", http.StatusBadRequest)
+		default:
+			loggerFrom(r.Context()).Warn("Fetching remote file failed", "url", remote.Redacted(), "error", err)
+			sendErrorResponse(w, "Error fetching url: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	pinBytesAndRespond(w, r, filenameFromURL(remote), data)
+}
+
+// errRemoteTooLarge is returned when a remote file exceeds maxPerFileSize.
+var errRemoteTooLarge = errors.New("remote file too large")
+
+// remoteStatusError is returned when the remote server responds with a
+// non-200 status.
+type remoteStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *remoteStatusError) Error() string {
+	return "remote server returned " + e.Status
+}
+
+// fetchRemoteFile downloads rawURL, stopping as soon as more than
+// maxPerFileSize bytes have been read.
+func fetchRemoteFile(ctx context.Context, rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, urlFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &remoteStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if resp.ContentLength > maxPerFileSize {
+		return nil, errRemoteTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxPerFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(data)) > maxPerFileSize {
+		return nil, errRemoteTooLarge
+	}
+
+	return data, nil
+}
+
+// filenameFromURL derives a filename from the last path segment of u.
+func filenameFromURL(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == "" {
+		return "download"
+	}
+	return name
+}
+
+// isPublicIP reports whether ip is globally routable.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType considers.
+const sniffLen = 512
+
+// allowedMIMETypes restricts uploads to these sniffed types. Entries may use
+// a "type/*" wildcard. An empty list allows every type.
+var allowedMIMETypes []string
+
+// maxImageWidth and maxImageHeight bound the pixel dimensions of image
+// uploads. Zero leaves a dimension unchecked.
+var maxImageWidth, maxImageHeight int
+
+// blockedExtensions holds lower-cased extensions, without the leading dot,
+// that are rejected by name. An empty entry blocks files with no extension.
+var blockedExtensions map[string]bool
+
+// parseBlockedExtensions normalizes BLOCKED_EXTENSIONS entries such as
+// ".EXE" or "sh". A lone "." is the marker for files without an extension.
+func parseBlockedExtensions(entries []string) map[string]bool {
+	blocked := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		blocked[strings.ToLower(strings.TrimPrefix(entry, "."))] = true
+	}
+	return blocked
+}
+
+// sanitizeFilename reduces a client-supplied filename to its base name and
+// drops control characters, keeping any Unicode text intact. Names that
+// contain null bytes or line breaks are rejected outright, and a name that
+// is empty once cleaned is replaced with a generated one.
+func sanitizeFilename(name string) (string, error) {
+	if strings.ContainsAny(name, "\x00\r\n") {
+		return "", withCode(CodeInvalidFilename, fmt.Errorf("file %q has an invalid name", name))
+	}
+
+	// Clients may send either separator regardless of the server's OS.
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+
+	if name == "" || name == "." || name == ".." {
+		return "upload-" + newRequestID()[:8], nil
+	}
+	return name, nil
+}
+
+// validateFile runs the per-file checks that must pass before a file is
+// uploaded. The returned error is reported to the client as-is.
+func validateFile(fh *multipart.FileHeader) error {
+	err := validateUpload(fh.Filename, fh.Size, func() (string, error) {
+		return sniffContentType(fh)
+	})
+	if err != nil || (maxImageWidth == 0 && maxImageHeight == 0) {
+		return err
+	}
+
+	// The file is reopened for upload, so nothing read here is lost.
+	file, err := fh.Open()
+	if err != nil {
+		return withCode(CodeInternal, fmt.Errorf("file %s could not be read: %v", fh.Filename, err))
+	}
+	defer file.Close()
+	return validateImageDimensions(fh.Filename, file)
+}
+
+// validateImageDimensions rejects images wider than maxImageWidth or taller
+// than maxImageHeight. Only the image header is read. Content that is not
+// a JPEG, PNG or GIF, the formats registered with the image package, is
+// not checked.
+func validateImageDimensions(filename string, r io.Reader) error {
+	if maxImageWidth == 0 && maxImageHeight == 0 {
+		return nil
+	}
+
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil
+	}
+	if maxImageWidth > 0 && config.Width > maxImageWidth {
+		return withCode(CodeImageTooLarge, fmt.Errorf("image %s is %dx%d pixels and exceeds the maximum width of %d", filename, config.Width, config.Height, maxImageWidth))
+	}
+	if maxImageHeight > 0 && config.Height > maxImageHeight {
+		return withCode(CodeImageTooLarge, fmt.Errorf("image %s is %dx%d pixels and exceeds the maximum height of %d", filename, config.Width, config.Height, maxImageHeight))
+	}
+	return nil
+}
+
+// validateExtension rejects filename when its extension is blocked. It is
+// the first check applied to any file.
+func validateExtension(filename string) error {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if blockedExtensions[ext] {
+		if ext == "" {
+			return withCode(CodeBlockedExtension, fmt.Errorf("file %s has no extension, which is not allowed", filename))
+		}
+		return withCode(CodeBlockedExtension, fmt.Errorf("file %s has blocked extension .%s", filename, ext))
+	}
+	return nil
+}
+
+// validateUpload checks a file's name, that it is neither empty nor too
+// large and, when an allowlist is configured, its content type. sniff is only
+// called when needed.
+func validateUpload(filename string, size int64, sniff func() (string, error)) error {
+	if err := validateExtension(filename); err != nil {
+		return err
+	}
+
+	if size == 0 {
+		return withCode(CodeEmptyFile, fmt.Errorf("file %s is empty", filename))
+	}
+	if size > maxPerFileSize {
+		return withCode(CodeFileTooLarge, fmt.Errorf("file %s is %d bytes and exceeds per-file limit of %d bytes", filename, size, maxPerFileSize))
+	}
+
+	if len(allowedMIMETypes) > 0 {
+		contentType, err := sniff()
+		if err != nil {
+			return withCode(CodeInternal, fmt.Errorf("file %s could not be read: %v", filename, err))
+		}
+		if !isAllowedMIMEType(contentType) {
+			return withCode(CodeInvalidMIME, fmt.Errorf("file %s has disallowed type %s", filename, contentType))
+		}
+	}
+
+	return nil
+}
+
+// sniffContentType detects the content type of fh from its leading bytes.
+// The file is reopened for upload, so nothing read here is lost.
+func sniffContentType(fh *multipart.FileHeader) (string, error) {
+	file, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// isAllowedMIMEType reports whether contentType matches allowedMIMETypes,
+// ignoring parameters such as charset.
+func isAllowedMIMEType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+
+	for _, allowed := range allowedMIMETypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+// userAgent is sent with every Pinata request so traffic can be traced back
+// to a build. HTTP_USER_AGENT replaces it.
+var userAgent = defaultUserAgent()
+
+// defaultUserAgent identifies the service, version and commit.
+func defaultUserAgent() string {
+	return fmt.Sprintf("%s/%s (commit %s)", serviceName, version, commit)
+}
+
+// VersionResponse is the body returned by /version.
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// handleVersion reports the running build. It never contacts Pinata.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(VersionResponse{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+	})
+}
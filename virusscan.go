@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClamAVTimeout = 60 * time.Second
+
+	// clamavChunkSize is the size of each INSTREAM chunk. clamd rejects
+	// chunks larger than its StreamMaxLength, which is far above this.
+	clamavChunkSize = 64 << 10
+)
+
+// clamd settings. Files are only scanned when clamavAddr, the host:port of
+// a clamd daemon, is set.
+var (
+	clamavAddr    string
+	clamavTimeout = defaultClamAVTimeout
+)
+
+// infectedError reports that clamd found a signature in a file.
+type infectedError struct {
+	Signature string
+}
+
+func (e *infectedError) Error() string {
+	return "infected with " + e.Signature
+}
+
+// scanFile scans an uploaded multipart file, returning an error naming the
+// file that is reported to the client as-is. It does nothing when scanning
+// is disabled.
+func scanFile(ctx context.Context, fh *multipart.FileHeader) error {
+	if clamavAddr == "" {
+		return nil
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return withCode(CodeInternal, fmt.Errorf("file %s could not be read: %v", fh.Filename, err))
+	}
+	defer file.Close()
+
+	return scanContent(ctx, fh.Filename, file)
+}
+
+// scanContent scans the content read from r, like scanFile.
+func scanContent(ctx context.Context, filename string, r io.Reader) error {
+	if clamavAddr == "" {
+		return nil
+	}
+
+	err := clamavScan(ctx, r)
+	var infected *infectedError
+	if errors.As(err, &infected) {
+		loggerFrom(ctx).Warn("Infected file rejected", "filename", filename, "signature", infected.Signature)
+		return withCode(CodeFileInfected, fmt.Errorf("file %s is %w", filename, err))
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("Virus scan failed", "filename", filename, "error", err)
+		return withCode(CodeScanFailed, fmt.Errorf("file %s could not be scanned for viruses: %w", filename, err))
+	}
+	return nil
+}
+
+// clamavScan streams r to clamd with the INSTREAM command, returning an
+// *infectedError when a signature matches.
+func clamavScan(ctx context.Context, r io.Reader) error {
+	ctx, cancel := context.WithTimeout(ctx, clamavTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", clamavAddr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Closing the connection unblocks any read or write when the request
+	// is canceled.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// The z prefix selects null-terminated commands and replies.
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return fmt.Errorf("failed to send to clamd: %w", err)
+	}
+	buf := make([]byte, 4+clamavChunkSize)
+	for {
+		n, readErr := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return fmt.Errorf("failed to send to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read file: %w", readErr)
+		}
+	}
+	// A zero-length chunk ends the stream.
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("failed to send to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamAVReply(strings.TrimSuffix(reply, "\x00"))
+}
+
+// parseClamAVReply interprets a reply such as "stream: OK" or
+// "stream: Eicar-Signature FOUND".
+func parseClamAVReply(reply string) error {
+	result := reply
+	if _, after, ok := strings.Cut(reply, ": "); ok {
+		result = after
+	}
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &infectedError{Signature: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("clamd returned %q", reply)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookTimeout bounds each webhook delivery.
+const webhookTimeout = 10 * time.Second
+
+var (
+	webhookURL    string
+	webhookSecret string
+)
+
+// WebhookPayload summarizes a completed upload batch.
+type WebhookPayload struct {
+	RequestID         string           `json:"request_id"`
+	SuccessfulUploads []UploadResponse `json:"successful_uploads"`
+	Errors            []string         `json:"errors"`
+	TotalSize         int64            `json:"total_size"`
+}
+
+// notifyWebhook delivers payload to WEBHOOK_URL in the background so the
+// client response is never delayed. It does nothing when no URL is set.
+func notifyWebhook(ctx context.Context, payload WebhookPayload) {
+	if webhookURL == "" {
+		return
+	}
+
+	log := loggerFrom(ctx)
+	go func() {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Error("Failed to encode webhook payload", "error", err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+		if err != nil {
+			log.Error("Failed to create webhook request", "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", payload.RequestID)
+		if webhookSecret != "" {
+			req.Header.Set("X-Signature", "sha256="+signWebhook(body))
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Warn("Webhook delivery failed", "error", withoutURL(err))
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			log.Warn("Webhook returned non-success status", "status_code", resp.StatusCode)
+		}
+	}()
+}
+
+// withoutURL strips the request URL from a client error, since webhook URLs
+// often embed a token and must not end up in logs.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// signWebhook returns the hex-encoded HMAC-SHA256 of body keyed with
+// WEBHOOK_SECRET.
+func signWebhook(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}